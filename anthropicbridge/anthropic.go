@@ -3,6 +3,7 @@ package anthropicbridge
 import (
 	"context"
 	"errors"
+	"figaro/llm"
 	"figaro/mcp"
 	"figaro/utils"
 	"os"
@@ -11,17 +12,23 @@ import (
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/anthropics/anthropic-sdk-go/packages/param"
+	"github.com/anthropics/anthropic-sdk-go/packages/ssestream"
 	"github.com/anthropics/anthropic-sdk-go/shared/constant"
+	"go.opentelemetry.io/otel/attribute"
 	"go.opentelemetry.io/otel/trace"
 )
 
 type AnthropicBridge struct {
 	tracerProvider trace.TracerProvider
 	client         *anthropic.Client
+	maxRetries     int
+	maxRetryDelay  time.Duration
 }
 
 type Opts struct {
 	tracerProvider trace.TracerProvider
+	maxRetries     int
+	maxRetryDelay  time.Duration
 }
 
 type OptsFunc func(o *Opts)
@@ -32,6 +39,15 @@ func WithLogging(tp trace.TracerProvider) OptsFunc {
 	}
 }
 
+// Retries requests turned down as rate limited or overloaded up to maxRetries times, waiting maxDelay at most in
+// all.
+func WithRetries(maxRetries int, maxDelay time.Duration) OptsFunc {
+	return func(o *Opts) {
+		o.maxRetries = maxRetries
+		o.maxRetryDelay = maxDelay
+	}
+}
+
 func InitAnthropic(opts ...OptsFunc) (AnthropicBridge, error) {
 	o := Opts{
 		tracerProvider: nil,
@@ -48,6 +64,8 @@ func InitAnthropic(opts ...OptsFunc) (AnthropicBridge, error) {
 	return AnthropicBridge{
 		tracerProvider: o.tracerProvider,
 		client:         client,
+		maxRetries:     o.maxRetries,
+		maxRetryDelay:  o.maxRetryDelay,
 	}, nil
 }
 
@@ -79,83 +97,137 @@ func GetAnthropicClient() (*anthropic.Client, error) {
 	if !ok {
 		return nil, errors.New("No ANTHROPIC_API_KEY found")
 	}
-	client := anthropic.NewClient(
+	opts := []option.RequestOption{
 		option.WithAPIKey(apiKey),
-	)
+		// the bridge retries itself, see retry.go
+		option.WithMaxRetries(0),
+	}
+	// for a proxy, or a stand-in server to test against
+	if baseURL, ok := os.LookupEnv("ANTHROPIC_BASE_URL"); ok && baseURL != "" {
+		opts = append(opts, option.WithBaseURL(baseURL))
+	}
+	client := anthropic.NewClient(opts...)
 	return &client, nil
 }
 
-type ConsoleStreamable[T any] struct {
-	Progress <-chan string
-	Result   <-chan T
-	Error    <-chan error
+// Sends a message and waits for the whole reply, for requests whose reply is not shown as it comes.
+func (bridge *AnthropicBridge) NewMessage(ctx context.Context, input anthropic.MessageNewParams) (*anthropic.Message, error) {
+	tracer := bridge.tracerProvider.Tracer("anthropicbridge")
+	ctx, span := tracer.Start(ctx, "NewMessage")
+	defer span.End()
+
+	retry := bridge.newRetrier(span)
+	for {
+		message, err := bridge.client.Messages.New(ctx, input)
+		if err == nil || !retry.wait(ctx, err) {
+			return message, err
+		}
+	}
 }
 
-// newer version that uses built in anthropic types
-func (bridge *AnthropicBridge) StreamMessage(
-	ctx context.Context,
-	input anthropic.MessageNewParams,
-) (
-	*ConsoleStreamable[*anthropic.Message],
-	error,
-) {
-	ctx, cancel := context.WithCancelCause(ctx)
+// Streams the reply as typed events, ending with the whole message or with the error the stream failed with.  A
+// stream that fails before any of the reply has come is retried like a request that fails outright, starting over
+// with a new message_start.
+func (bridge *AnthropicBridge) StreamMessage(ctx context.Context, input anthropic.MessageNewParams) (<-chan llm.StreamEvent, error) {
 	tracer := bridge.tracerProvider.Tracer("anthropicbridge")
 	ctx, span := tracer.Start(ctx, "StreamMessage")
 
-	client := bridge.client
-	stream := client.Messages.NewStreaming(ctx, input)
-
-	err := stream.Err()
+	retry := bridge.newRetrier(span)
+	stream, err := bridge.openStream(ctx, input, retry)
 	if err != nil {
-		cancel(err)
 		span.End(trace.WithStackTrace(true), trace.WithTimestamp(time.Now()))
-		return nil, stream.Err()
+		return nil, err
 	}
 
-	errCh := make(chan error)
-	message := anthropic.Message{}
-	progress := make(chan string, 1)
-	result := make(chan *anthropic.Message)
-
-	go func() {
-		defer close(errCh)
-		select {
-		case <-ctx.Done():
-			err := ctx.Err()
-			if err != nil {
-				errCh <- err
-			}
-		}
-	}()
-
+	// unbuffered, so that every event is taken in order, thinking before the text that follows it
+	events := make(chan llm.StreamEvent)
 	go func() {
-		defer close(progress)
-		defer close(result)
+		defer close(events)
 		defer span.End()
-		defer cancel(nil)
-		for stream.Next() {
-			event := stream.Current()
-			err := message.Accumulate(event)
-			if err != nil {
-				cancel(err)
-			}
 
-			switch variant := event.AsAny().(type) {
-			case anthropic.ContentBlockDeltaEvent:
-				switch deltaVariant := variant.Delta.AsAny().(type) {
-				case anthropic.TextDelta:
-					progress <- deltaVariant.Text
-				default:
-					// progress <- reflect.TypeOf(deltaVariant).Name() + "\n"
+		for {
+			shown, err := relay(stream, events)
+			stream.Close()
+			if err == nil {
+				return
+			}
+			if !shown && retry.wait(ctx, err) {
+				stream, err = bridge.openStream(ctx, input, retry)
+				if err == nil {
+					continue
 				}
 			}
+			span.AddEvent("Stream failed", trace.WithAttributes(attribute.String("error", err.Error())))
+			events <- llm.StreamEvent{Type: llm.StreamError, Err: err}
+			return
 		}
-		result <- &message
 	}()
+	return events, nil
+}
 
-	return &ConsoleStreamable[*anthropic.Message]{
-		Progress: progress,
-		Result:   result,
-	}, nil
+// Starts a stream, retrying while the API turns it down.
+func (bridge *AnthropicBridge) openStream(
+	ctx context.Context,
+	input anthropic.MessageNewParams,
+	retry *retrier,
+) (*ssestream.Stream[anthropic.MessageStreamEventUnion], error) {
+	for {
+		stream := bridge.client.Messages.NewStreaming(ctx, input)
+		err := stream.Err()
+		if err == nil {
+			return stream, nil
+		}
+		stream.Close()
+		if !retry.wait(ctx, err) {
+			return nil, err
+		}
+	}
+}
+
+// Passes on the events of stream, ending with the whole message, unless the stream fails.  Returns the error it
+// failed with, and whether any of the reply had been passed on by then.
+func relay(stream *ssestream.Stream[anthropic.MessageStreamEventUnion], events chan<- llm.StreamEvent) (bool, error) {
+	message := anthropic.Message{}
+	shown := false
+	for stream.Next() {
+		event := stream.Current()
+		if err := message.Accumulate(event); err != nil {
+			return shown, err
+		}
+
+		switch variant := event.AsAny().(type) {
+		case anthropic.MessageStartEvent:
+			events <- llm.StreamEvent{Type: llm.StreamMessageStart, ID: variant.Message.ID, Usage: message.Usage}
+		case anthropic.ContentBlockStartEvent:
+			if toolUse, ok := variant.ContentBlock.AsAny().(anthropic.ToolUseBlock); ok {
+				shown = true
+				events <- llm.StreamEvent{Type: llm.StreamToolUseStart, Index: int(variant.Index), ID: toolUse.ID, Name: toolUse.Name}
+			}
+		case anthropic.ContentBlockDeltaEvent:
+			shown = true
+			index := int(variant.Index)
+			switch deltaVariant := variant.Delta.AsAny().(type) {
+			case anthropic.TextDelta:
+				events <- llm.StreamEvent{Type: llm.StreamTextDelta, Index: index, Text: deltaVariant.Text}
+			case anthropic.ThinkingDelta:
+				events <- llm.StreamEvent{Type: llm.StreamThinkingDelta, Index: index, Text: deltaVariant.Thinking}
+			case anthropic.SignatureDelta:
+				events <- llm.StreamEvent{Type: llm.StreamThinkingDelta, Index: index, Signature: deltaVariant.Signature}
+			case anthropic.InputJSONDelta:
+				events <- llm.StreamEvent{Type: llm.StreamInputJSONDelta, Index: index, Text: deltaVariant.PartialJSON}
+			}
+		case anthropic.ContentBlockStopEvent:
+			events <- llm.StreamEvent{Type: llm.StreamContentBlockStop, Index: int(variant.Index)}
+		case anthropic.MessageDeltaEvent:
+			events <- llm.StreamEvent{Type: llm.StreamUsage, Usage: message.Usage}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return shown, err
+	}
+	if message.StopReason == "" {
+		return shown, errors.New("the stream ended before the reply was done")
+	}
+	events <- llm.StreamEvent{Type: llm.StreamMessageStop, Message: &message}
+	return shown, nil
 }
@@ -0,0 +1,125 @@
+package anthropicbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var ErrBatchTools = errors.New("tool use is not supported in batches")
+
+// One request of a batch, whose result comes back under the same CustomID.
+type BatchRequest struct {
+	CustomID string
+	Params   anthropic.MessageNewParams
+}
+
+// Submits requests to the Message Batches API, which serves them within a day at half the price.  Nobody is there
+// to run tools for the model in the meantime, so requests offering tools are refused.
+func (bridge *AnthropicBridge) SubmitBatch(ctx context.Context, requests []BatchRequest) (*anthropic.MessageBatch, error) {
+	tracer := bridge.tracerProvider.Tracer("anthropicbridge")
+	ctx, span := tracer.Start(ctx, "SubmitBatch")
+	defer span.End()
+
+	batch := anthropic.MessageBatchNewParams{
+		Requests: make([]anthropic.MessageBatchNewParamsRequest, 0, len(requests)),
+	}
+	for _, request := range requests {
+		params := request.Params
+		if len(params.Tools) > 0 {
+			return nil, fmt.Errorf("%s: %w", request.CustomID, ErrBatchTools)
+		}
+		batch.Requests = append(batch.Requests, anthropic.MessageBatchNewParamsRequest{
+			CustomID: request.CustomID,
+			Params: anthropic.MessageBatchNewParamsRequestParams{
+				MaxTokens:     params.MaxTokens,
+				Messages:      params.Messages,
+				Model:         params.Model,
+				Temperature:   params.Temperature,
+				TopK:          params.TopK,
+				TopP:          params.TopP,
+				Metadata:      params.Metadata,
+				StopSequences: params.StopSequences,
+				System:        params.System,
+				Thinking:      params.Thinking,
+			},
+		})
+	}
+	span.AddEvent("Submitting batch", trace.WithAttributes(attribute.Int("requests", len(requests))))
+
+	retry := bridge.newRetrier(span)
+	for {
+		created, err := bridge.client.Messages.Batches.New(ctx, batch)
+		if err == nil || !retry.wait(ctx, err) {
+			return created, err
+		}
+	}
+}
+
+// Returns where the batch is at: how many of its requests are done, and whether the results are ready.
+func (bridge *AnthropicBridge) GetBatch(ctx context.Context, id string) (*anthropic.MessageBatch, error) {
+	tracer := bridge.tracerProvider.Tracer("anthropicbridge")
+	ctx, span := tracer.Start(ctx, "GetBatch")
+	defer span.End()
+
+	retry := bridge.newRetrier(span)
+	for {
+		batch, err := bridge.client.Messages.Batches.Get(ctx, id)
+		if err == nil || !retry.wait(ctx, err) {
+			return batch, err
+		}
+	}
+}
+
+// Calls each with every result of an ended batch, in no particular order.  Stops at the first error each returns.
+func (bridge *AnthropicBridge) BatchResults(
+	ctx context.Context,
+	id string,
+	each func(anthropic.MessageBatchIndividualResponse) error,
+) error {
+	tracer := bridge.tracerProvider.Tracer("anthropicbridge")
+	ctx, span := tracer.Start(ctx, "BatchResults")
+	defer span.End()
+
+	// read here rather than through the sdk's results stream, whose lines are limited to 64KB
+	var response *http.Response
+	retry := bridge.newRetrier(span)
+	for {
+		err := bridge.client.Get(ctx, "v1/messages/batches/"+url.PathEscape(id)+"/results", nil, &response,
+			option.WithHeader("Accept", "application/x-jsonl"))
+		if err == nil {
+			break
+		}
+		if !retry.wait(ctx, err) {
+			return err
+		}
+	}
+	defer response.Body.Close()
+
+	decoder := json.NewDecoder(response.Body)
+	count := 0
+	for {
+		var result anthropic.MessageBatchIndividualResponse
+		err := decoder.Decode(&result)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to read the results: %w", err)
+		}
+		count++
+		if err := each(result); err != nil {
+			return err
+		}
+	}
+	span.AddEvent("Results read", trace.WithAttributes(attribute.Int("results", count)))
+	return nil
+}
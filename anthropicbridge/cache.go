@@ -0,0 +1,81 @@
+package anthropicbridge
+
+import (
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+var ephemeral = anthropic.CacheControlEphemeralParam{Type: "ephemeral"}
+
+// SetCacheBreakpoints marks what stays the same from one turn to the next for prompt caching: the tool definitions,
+// the system prompt and the conversation up to the latest message, as well as up to the user turn before it, which
+// the previous request ended with.  That is four breakpoints, as many as the API takes.  The conversation is copied
+// where marked, so that the breakpoints do not pile up in it from turn to turn.
+func SetCacheBreakpoints(params *anthropic.MessageNewParams) {
+	if n := len(params.Tools); n > 0 {
+		tools := make([]anthropic.ToolUnionParam, n)
+		copy(tools, params.Tools)
+		if tools[n-1].OfTool != nil {
+			tool := *tools[n-1].OfTool
+			tool.CacheControl = ephemeral
+			tools[n-1].OfTool = &tool
+		}
+		params.Tools = tools
+	}
+
+	if n := len(params.System); n > 0 {
+		system := make([]anthropic.TextBlockParam, n)
+		copy(system, params.System)
+		system[n-1].CacheControl = ephemeral
+		params.System = system
+	}
+
+	messages := make([]anthropic.MessageParam, len(params.Messages))
+	copy(messages, params.Messages)
+	for _, i := range []int{len(messages) - 1, len(messages) - 3} {
+		if i >= 0 {
+			messages[i] = withCacheBreakpoint(messages[i])
+		}
+	}
+	params.Messages = messages
+}
+
+// Returns a copy of message with a breakpoint on its last block that takes one; thinking blocks do not.
+func withCacheBreakpoint(message anthropic.MessageParam) anthropic.MessageParam {
+	content := make([]anthropic.ContentBlockParamUnion, len(message.Content))
+	copy(content, message.Content)
+	for i := len(content) - 1; i >= 0; i-- {
+		if marked, ok := markBlock(content[i]); ok {
+			content[i] = marked
+			break
+		}
+	}
+	message.Content = content
+	return message
+}
+
+func markBlock(block anthropic.ContentBlockParamUnion) (anthropic.ContentBlockParamUnion, bool) {
+	switch {
+	case block.OfRequestTextBlock != nil:
+		text := *block.OfRequestTextBlock
+		text.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestTextBlock: &text}, true
+	case block.OfRequestImageBlock != nil:
+		image := *block.OfRequestImageBlock
+		image.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestImageBlock: &image}, true
+	case block.OfRequestToolUseBlock != nil:
+		toolUse := *block.OfRequestToolUseBlock
+		toolUse.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestToolUseBlock: &toolUse}, true
+	case block.OfRequestToolResultBlock != nil:
+		toolResult := *block.OfRequestToolResultBlock
+		toolResult.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestToolResultBlock: &toolResult}, true
+	case block.OfRequestDocumentBlock != nil:
+		document := *block.OfRequestDocumentBlock
+		document.CacheControl = ephemeral
+		return anthropic.ContentBlockParamUnion{OfRequestDocumentBlock: &document}, true
+	default:
+		return block, false
+	}
+}
@@ -0,0 +1,178 @@
+package anthropicbridge
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// The sdk's param types only know how to marshal themselves, so a conversation read back from disk is decoded
+// through these mirrors of the wire format first.
+type wireMessage struct {
+	Role    anthropic.MessageParamRole `json:"role"`
+	Content []wireBlock                `json:"content"`
+}
+
+type wireBlock struct {
+	Type string `json:"type"`
+
+	// text
+	Text string `json:"text,omitempty"`
+
+	// image, document
+	Source  *wireSource `json:"source,omitempty"`
+	Title   *string     `json:"title,omitempty"`
+	Context *string     `json:"context,omitempty"`
+
+	// tool_use
+	ID    string          `json:"id,omitempty"`
+	Name  string          `json:"name,omitempty"`
+	Input json.RawMessage `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string      `json:"tool_use_id,omitempty"`
+	IsError   *bool       `json:"is_error,omitempty"`
+	Content   []wireBlock `json:"content,omitempty"`
+
+	// thinking, redacted_thinking
+	Signature string `json:"signature,omitempty"`
+	Thinking  string `json:"thinking,omitempty"`
+	Data      string `json:"data,omitempty"`
+}
+
+type wireSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+// UnmarshalConversation decodes a conversation previously marshalled from []anthropic.MessageParam.
+func UnmarshalConversation(data []byte) ([]anthropic.MessageParam, error) {
+	var messages []wireMessage
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, err
+	}
+
+	conversation := make([]anthropic.MessageParam, 0, len(messages))
+	for i, message := range messages {
+		content := make([]anthropic.ContentBlockParamUnion, 0, len(message.Content))
+		for j, block := range message.Content {
+			param, err := block.toParam()
+			if err != nil {
+				return nil, fmt.Errorf("message %d, block %d: %w", i, j, err)
+			}
+			content = append(content, param)
+		}
+		conversation = append(conversation, anthropic.MessageParam{
+			Role:    message.Role,
+			Content: content,
+		})
+	}
+	return conversation, nil
+}
+
+func (block wireBlock) toParam() (anthropic.ContentBlockParamUnion, error) {
+	switch block.Type {
+	case "text":
+		return anthropic.NewTextBlock(block.Text), nil
+	case "image":
+		image, err := block.toImage()
+		if err != nil {
+			return anthropic.ContentBlockParamUnion{}, err
+		}
+		return anthropic.ContentBlockParamUnion{OfRequestImageBlock: image}, nil
+	case "document":
+		document, err := block.toDocument()
+		if err != nil {
+			return anthropic.ContentBlockParamUnion{}, err
+		}
+		return anthropic.ContentBlockParamUnion{OfRequestDocumentBlock: document}, nil
+	case "tool_use":
+		input := block.Input
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		}
+		return anthropic.ContentBlockParamOfRequestToolUseBlock(block.ID, input, block.Name), nil
+	case "tool_result":
+		result := anthropic.ToolResultBlockParam{ToolUseID: block.ToolUseID}
+		if block.IsError != nil {
+			result.IsError = anthropic.Bool(*block.IsError)
+		}
+		for _, inner := range block.Content {
+			switch inner.Type {
+			case "text":
+				result.Content = append(result.Content, anthropic.ToolResultBlockParamContentUnion{
+					OfRequestTextBlock: &anthropic.TextBlockParam{Text: inner.Text},
+				})
+			case "image":
+				image, err := inner.toImage()
+				if err != nil {
+					return anthropic.ContentBlockParamUnion{}, err
+				}
+				result.Content = append(result.Content, anthropic.ToolResultBlockParamContentUnion{
+					OfRequestImageBlock: image,
+				})
+			default:
+				return anthropic.ContentBlockParamUnion{}, fmt.Errorf("unsupported tool result content %q", inner.Type)
+			}
+		}
+		return anthropic.ContentBlockParamUnion{OfRequestToolResultBlock: &result}, nil
+	case "thinking":
+		return anthropic.ContentBlockParamOfRequestThinkingBlock(block.Signature, block.Thinking), nil
+	case "redacted_thinking":
+		return anthropic.ContentBlockParamOfRequestRedactedThinkingBlock(block.Data), nil
+	default:
+		return anthropic.ContentBlockParamUnion{}, fmt.Errorf("unsupported content block %q", block.Type)
+	}
+}
+
+func (block wireBlock) toImage() (*anthropic.ImageBlockParam, error) {
+	if block.Source == nil {
+		return nil, fmt.Errorf("image block has no source")
+	}
+	switch block.Source.Type {
+	case "base64":
+		return &anthropic.ImageBlockParam{
+			Source: anthropic.ImageBlockParamSourceUnion{
+				OfBase64ImageSource: &anthropic.Base64ImageSourceParam{
+					Data:      block.Source.Data,
+					MediaType: anthropic.Base64ImageSourceMediaType(block.Source.MediaType),
+				},
+			},
+		}, nil
+	case "url":
+		return &anthropic.ImageBlockParam{
+			Source: anthropic.ImageBlockParamSourceUnion{
+				OfURLImageSource: &anthropic.URLImageSourceParam{URL: block.Source.URL},
+			},
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported image source %q", block.Source.Type)
+	}
+}
+
+func (block wireBlock) toDocument() (*anthropic.DocumentBlockParam, error) {
+	if block.Source == nil {
+		return nil, fmt.Errorf("document block has no source")
+	}
+	document := anthropic.DocumentBlockParam{}
+	switch block.Source.Type {
+	case "base64":
+		document.Source.OfBase64PDFSource = &anthropic.Base64PDFSourceParam{Data: block.Source.Data}
+	case "text":
+		document.Source.OfPlainTextSource = &anthropic.PlainTextSourceParam{Data: block.Source.Data}
+	case "url":
+		document.Source.OfUrlpdfSource = &anthropic.URLPDFSourceParam{URL: block.Source.URL}
+	default:
+		return nil, fmt.Errorf("unsupported document source %q", block.Source.Type)
+	}
+	if block.Title != nil {
+		document.Title = anthropic.String(*block.Title)
+	}
+	if block.Context != nil {
+		document.Context = anthropic.String(*block.Context)
+	}
+	return &document, nil
+}
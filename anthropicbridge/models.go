@@ -0,0 +1,69 @@
+package anthropicbridge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+const DefaultModel = anthropic.ModelClaude3_7SonnetLatest
+
+// A model known to the sdk, along with the name of the constant declaring it.
+type ModelInfo struct {
+	ID         anthropic.Model
+	Constant   string
+	Deprecated bool
+}
+
+// Mirrors the model constants of the sdk, which cannot be enumerated at runtime.
+var KnownModels = []ModelInfo{
+	{ID: anthropic.ModelClaude3_7SonnetLatest, Constant: "ModelClaude3_7SonnetLatest"},
+	{ID: anthropic.ModelClaude3_7Sonnet20250219, Constant: "ModelClaude3_7Sonnet20250219"},
+	{ID: anthropic.ModelClaude3_5HaikuLatest, Constant: "ModelClaude3_5HaikuLatest"},
+	{ID: anthropic.ModelClaude3_5Haiku20241022, Constant: "ModelClaude3_5Haiku20241022"},
+	{ID: anthropic.ModelClaude3_5SonnetLatest, Constant: "ModelClaude3_5SonnetLatest"},
+	{ID: anthropic.ModelClaude3_5Sonnet20241022, Constant: "ModelClaude3_5Sonnet20241022"},
+	{ID: anthropic.ModelClaude_3_5_Sonnet_20240620, Constant: "ModelClaude_3_5_Sonnet_20240620"},
+	{ID: anthropic.ModelClaude3OpusLatest, Constant: "ModelClaude3OpusLatest"},
+	{ID: anthropic.ModelClaude_3_Opus_20240229, Constant: "ModelClaude_3_Opus_20240229"},
+	{ID: anthropic.ModelClaude_3_Sonnet_20240229, Constant: "ModelClaude_3_Sonnet_20240229", Deprecated: true},
+	{ID: anthropic.ModelClaude_3_Haiku_20240307, Constant: "ModelClaude_3_Haiku_20240307"},
+	{ID: anthropic.ModelClaude_2_1, Constant: "ModelClaude_2_1", Deprecated: true},
+	{ID: anthropic.ModelClaude_2_0, Constant: "ModelClaude_2_0", Deprecated: true},
+}
+
+// Resolves a user provided model name to a model id.  The name may be a model id, the name of an sdk constant or
+// one of the provided aliases.  An alias may point at any id, even one the sdk does not know about yet, which is the
+// escape hatch for models released after the sdk was.
+func ResolveModel(name string, aliases map[string]string) (anthropic.Model, error) {
+	if target, ok := aliases[name]; ok {
+		if model, ok := lookupModel(target); ok {
+			return model, nil
+		}
+		return target, nil
+	}
+	if model, ok := lookupModel(name); ok {
+		return model, nil
+	}
+	return "", fmt.Errorf(`unknown model %q, run "figaro models" to list the choices`, name)
+}
+
+func lookupModel(name string) (anthropic.Model, bool) {
+	for _, model := range KnownModels {
+		if name == model.ID || name == model.Constant {
+			return model.ID, true
+		}
+	}
+	return "", false
+}
+
+// Returns the alias names in a stable order.
+func SortedAliases(aliases map[string]string) []string {
+	names := make([]string, 0, len(aliases))
+	for name := range aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
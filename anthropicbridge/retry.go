@@ -0,0 +1,124 @@
+package anthropicbridge
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	initialRetryDelay = time.Second
+	maxRetryDelay     = 30 * time.Second
+	// how the sdk reports an error event in the middle of a stream
+	streamErrorPrefix = "received error while streaming: "
+)
+
+// Keeps count of the retries of one request, so that they stay within the configured attempts and total delay.
+type retrier struct {
+	span       trace.Span
+	maxRetries int
+	maxDelay   time.Duration
+	attempts   int
+	waited     time.Duration
+}
+
+func (bridge *AnthropicBridge) newRetrier(span trace.Span) *retrier {
+	return &retrier{span: span, maxRetries: bridge.maxRetries, maxDelay: bridge.maxRetryDelay}
+}
+
+// Waits before trying again after err, if err is worth retrying and the retries are not used up.  Returns whether
+// to try again.
+func (r *retrier) wait(ctx context.Context, err error) bool {
+	retryAfter, ok := retryable(err)
+	if !ok || r.attempts >= r.maxRetries {
+		return false
+	}
+	delay := retryAfter
+	if delay <= 0 {
+		delay = backoff(r.attempts)
+	}
+	if r.waited+delay > r.maxDelay {
+		r.span.AddEvent("Giving up on retries", trace.WithAttributes(
+			attribute.Int("attempts", r.attempts),
+			attribute.String("waited", r.waited.String()),
+			attribute.String("delay", delay.String())))
+		return false
+	}
+	r.attempts++
+	r.waited += delay
+	r.span.AddEvent("Retrying", trace.WithAttributes(
+		attribute.Int("attempt", r.attempts),
+		attribute.String("delay", delay.String()),
+		attribute.Bool("retry_after", retryAfter > 0),
+		attribute.String("error", err.Error())))
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Doubles the delay with every attempt, up to a cap, and picks at random in its upper half so that clients turned
+// down together do not all come back at once.
+func backoff(attempt int) time.Duration {
+	delay := min(initialRetryDelay<<attempt, maxRetryDelay)
+	return delay/2 + rand.N(delay/2)
+}
+
+// Tells whether err is the API turning the request down as rate limited (429) or overloaded (529), and how long it
+// asked to be left alone for, if it did.
+func retryable(err error) (time.Duration, bool) {
+	var apiError *anthropic.Error
+	if errors.As(err, &apiError) {
+		if apiError.StatusCode != http.StatusTooManyRequests && apiError.StatusCode != 529 {
+			return 0, false
+		}
+		if apiError.Response == nil {
+			return 0, true
+		}
+		return retryAfter(apiError.Response.Header), true
+	}
+
+	// an error event in a stream that has already started, which carries no headers
+	data, ok := strings.CutPrefix(err.Error(), streamErrorPrefix)
+	if !ok {
+		return 0, false
+	}
+	var event struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	if json.Unmarshal([]byte(data), &event) != nil {
+		return 0, false
+	}
+	return 0, event.Error.Type == "rate_limit_error" || event.Error.Type == "overloaded_error"
+}
+
+// Reads retry-after-ms, or retry-after in seconds or as a date.
+func retryAfter(header http.Header) time.Duration {
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
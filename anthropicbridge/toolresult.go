@@ -0,0 +1,100 @@
+package anthropicbridge
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"figaro/mcp"
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// The image formats the model accepts.
+var imageMediaTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+	"image/webp": true,
+}
+
+// IsImageMediaType tells whether the model accepts images of mediaType.
+func IsImageMediaType(mediaType string) bool {
+	return imageMediaTypes[mediaType]
+}
+
+// GetToolResultContent turns the content of a decoded CallToolResult into tool_result content for the model.
+// Text and images are passed on as they are, text resources as text, and anything the model cannot take, such as
+// audio, as a short description saying what was left out.
+func GetToolResultContent(result *mcp.CallToolResult) []anthropic.ToolResultBlockParamContentUnion {
+	content := make([]anthropic.ToolResultBlockParamContentUnion, 0, len(result.Content))
+	for _, item := range result.Content {
+		// the api refuses empty text blocks
+		if text, ok := item.(mcp.TextContent); ok && text.Text == "" {
+			continue
+		}
+		content = append(content, getToolResultContentItem(item))
+	}
+	return content
+}
+
+func getToolResultContentItem(item any) anthropic.ToolResultBlockParamContentUnion {
+	switch item := item.(type) {
+	case mcp.TextContent:
+		return textContent(item.Text)
+	case mcp.ImageContent:
+		if imageMediaTypes[item.MimeType] {
+			return imageContent(item.MimeType, item.Data)
+		}
+		return textContent(fmt.Sprintf("[image of type %s, %s, left out: the model only reads jpeg, png, gif and webp]",
+			item.MimeType, encodedSize(item.Data)))
+	case mcp.AudioContent:
+		return textContent(fmt.Sprintf("[audio of type %s, %s, left out: the model cannot listen to audio]",
+			item.MimeType, encodedSize(item.Data)))
+	case mcp.EmbeddedResource:
+		switch resource := item.Resource.(type) {
+		case mcp.TextResourceContents:
+			return textContent(fmt.Sprintf("<resource uri=%q>\n%s\n</resource>", resource.URI, resource.Text))
+		case mcp.BlobResourceContents:
+			mimeType := "application/octet-stream"
+			if resource.MimeType != nil {
+				mimeType = *resource.MimeType
+			}
+			if imageMediaTypes[mimeType] {
+				return imageContent(mimeType, resource.Blob)
+			}
+			return textContent(fmt.Sprintf("[resource %s of type %s, %s of binary data, left out]",
+				resource.URI, mimeType, encodedSize(resource.Blob)))
+		}
+	}
+
+	raw, err := json.Marshal(item)
+	if err != nil {
+		raw = []byte(fmt.Sprintf("%v", item))
+	}
+	return textContent(fmt.Sprintf("[content the model cannot be shown, as received: %s]", raw))
+}
+
+func textContent(text string) anthropic.ToolResultBlockParamContentUnion {
+	return anthropic.ToolResultBlockParamContentUnion{
+		OfRequestTextBlock: &anthropic.TextBlockParam{Text: text},
+	}
+}
+
+func imageContent(mediaType string, data string) anthropic.ToolResultBlockParamContentUnion {
+	return anthropic.ToolResultBlockParamContentUnion{
+		OfRequestImageBlock: &anthropic.ImageBlockParam{
+			Source: anthropic.ImageBlockParamSourceUnion{
+				OfBase64ImageSource: &anthropic.Base64ImageSourceParam{
+					Data:      data,
+					MediaType: anthropic.Base64ImageSourceMediaType(mediaType),
+				},
+			},
+		},
+	}
+}
+
+// The size of base64 data once decoded, for descriptions.
+func encodedSize(data string) string {
+	return fmt.Sprintf("%d bytes", base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(data, "="))))
+}
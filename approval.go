@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"figaro/figaro"
+	"figaro/mcp"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Sets up tool approval as --yes and --read-only say, for the project in the working directory.
+func newApprovals(yes bool, readOnly bool) (*figaro.Approvals, error) {
+	mode := figaro.ApprovalAsk
+	switch {
+	case yes && readOnly:
+		return nil, errors.New("--yes and --read-only cannot be used together")
+	case yes:
+		mode = figaro.ApprovalYes
+	case readOnly:
+		mode = figaro.ApprovalReadOnly
+	}
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return figaro.NewApprovals(mode, workingDir, promptApproval)
+}
+
+// Asks on the terminal whether a tool may run.  The terminal is opened directly, since stdin may be a pipe
+// holding the prompt and stdout may be taken by --output.
+func promptApproval(ctx context.Context, tool mcp.Tool, input json.RawMessage) (figaro.Answer, string, error) {
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		return figaro.Deny, "", errors.New("no terminal to ask for approval on, rerun with --yes or --read-only")
+	}
+	defer tty.Close()
+	// closing the terminal is what stops waiting for an answer when Ctrl-C interrupts the request
+	stop := context.AfterFunc(ctx, func() { tty.Close() })
+	defer stop()
+
+	description := tool.Name
+	if hints := toolHints(tool.Annotations); len(hints) > 0 {
+		description += " (" + strings.Join(hints, ", ") + ")"
+	}
+	var arguments bytes.Buffer
+	if json.Indent(&arguments, input, "  ", "  ") != nil {
+		arguments.Reset()
+		arguments.Write(input)
+	}
+	fmt.Fprintf(tty, "\n%s wants to run with:\n  %s\n", description, arguments.String())
+
+	reader := bufio.NewReader(tty)
+	for {
+		fmt.Fprint(tty, "Allow? [y] once, [a] always for this tool, [n] no: ")
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return figaro.Deny, "", err
+		}
+		switch strings.ToLower(strings.TrimSpace(line)) {
+		case "y", "yes":
+			return figaro.AllowOnce, "", nil
+		case "a", "always":
+			return figaro.AllowAlways, "", nil
+		case "n", "no":
+			fmt.Fprint(tty, "Tell the model why, or what to do instead (optional): ")
+			feedback, err := reader.ReadString('\n')
+			if err != nil {
+				return figaro.Deny, "", err
+			}
+			return figaro.Deny, strings.TrimSpace(feedback), nil
+		}
+	}
+}
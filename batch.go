@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"figaro/anthropicbridge"
+	"figaro/config"
+	"figaro/figaro"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Submits prompts to the Message Batches API, checks on the batches and writes out their results.
+func runBatchCommand(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	action, args, err := subcommand(args, "submit", "status", "results")
+	if err != nil {
+		return err
+	}
+
+	bridge, err := anthropicbridge.InitAnthropic(
+		anthropicbridge.WithLogging(tp),
+		anthropicbridge.WithRetries(cfg.Limits.GetMaxRetries(), cfg.Limits.GetMaxRetryDelay()),
+	)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "submit":
+		return submitBatch(ctx, &bridge, cfg, args)
+	case "status":
+		return batchStatus(ctx, &bridge, args)
+	default:
+		return batchResults(ctx, &bridge, args)
+	}
+}
+
+// One line of the prompts file.
+type batchPrompt struct {
+	customID string
+	text     string
+}
+
+var customIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Sends every prompt of the file as a request of its own, with the model, system prompt and generation settings a
+// prompt would be sent with, but no tools: the servers are not started, as nobody would be there to call them.
+func submitBatch(ctx context.Context, bridge *anthropicbridge.AnthropicBridge, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("batch submit", flag.ContinueOnError)
+	modelName := flags.String("m", "", "The `model` to use, as -m takes it")
+	templatePath := flags.String("template", "", "Fill the prompt template in `file` in with each line")
+	system := flags.String("system", "", "System prompt, added after any discovered instructions")
+	systemFile := flags.String("system-file", "", "Read the system prompt from `file`")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: figaro batch submit [-m model] [--template file] [--system text] [--system-file file] <prompts.jsonl>")
+	}
+
+	model, err := selectModel(cfg, *modelName, *modelName != "", "")
+	if err != nil {
+		return err
+	}
+	if provider, _, ok := config.SplitModel(model, cfg.Providers); ok {
+		return fmt.Errorf("batches go through the Anthropic API, not provider %q", provider)
+	}
+	if err := cfg.Generation.Validate(); err != nil {
+		return err
+	}
+
+	var prompt *template.Template
+	if *templatePath != "" {
+		prompt, err = template.New(filepath.Base(*templatePath)).Option("missingkey=error").ParseFiles(*templatePath)
+		if err != nil {
+			return err
+		}
+	}
+	prompts, err := readBatchPrompts(args[0], prompt)
+	if err != nil {
+		return err
+	}
+
+	systemParts, err := systemPrompt(*system, *systemFile)
+	if err != nil {
+		return err
+	}
+	systemBlocks := make([]anthropic.TextBlockParam, 0, len(systemParts))
+	for _, part := range systemParts {
+		if part != "" {
+			systemBlocks = append(systemBlocks, anthropic.TextBlockParam{Text: part})
+		}
+	}
+
+	requests := make([]anthropicbridge.BatchRequest, 0, len(prompts))
+	customIDs := make([]string, 0, len(prompts))
+	for _, prompt := range prompts {
+		conversation := []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock(prompt.text))}
+		params := figaro.GetMessageNewParams(conversation, nil, model, systemBlocks, cfg.Generation)
+		// the system prompt is shared between the requests and worth caching, each prompt is not
+		params.Messages = conversation
+		requests = append(requests, anthropicbridge.BatchRequest{CustomID: prompt.customID, Params: *params})
+		customIDs = append(customIDs, prompt.customID)
+	}
+
+	created, err := bridge.SubmitBatch(ctx, requests)
+	if err != nil {
+		return &figaro.ModelError{Err: err}
+	}
+	input, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	batch := &figaro.Batch{
+		ID:          created.ID,
+		Model:       model,
+		Input:       input,
+		SubmittedAt: time.Now(),
+		CustomIDs:   customIDs,
+		Status:      string(created.ProcessingStatus),
+		Counts:      created.RequestCounts,
+	}
+	if err := batch.Save(); err != nil {
+		return fmt.Errorf("submitted batch %s but could not keep track of it: %w", created.ID, err)
+	}
+	fmt.Printf("submitted %s with %d requests, run 'figaro batch status' to check on it\n", created.ID, len(requests))
+	return nil
+}
+
+// Reads one prompt per line: a json string, or an object with a "prompt".  With a template, the line is what the
+// template is filled in with instead, an object's fields by name or a string as {{.}}.  An object's "custom_id"
+// names its result; other lines are named after their line number.
+func readBatchPrompts(path string, prompt *template.Template) ([]batchPrompt, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	prompts := make([]batchPrompt, 0)
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var value any
+		if err := json.Unmarshal(scanner.Bytes(), &value); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		next := batchPrompt{customID: fmt.Sprintf("line-%d", line)}
+		fields, isObject := value.(map[string]any)
+		if isObject {
+			if _, ok := fields["tools"]; ok {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, anthropicbridge.ErrBatchTools)
+			}
+			if id, ok := fields["custom_id"]; ok {
+				next.customID, ok = id.(string)
+				if !ok || !customIDPattern.MatchString(next.customID) {
+					return nil, fmt.Errorf("%s:%d: custom_id must be 1 to 64 letters, digits, '_' or '-'", path, line)
+				}
+			}
+		}
+		if seen[next.customID] {
+			return nil, fmt.Errorf("%s:%d: custom_id %q is used more than once", path, line, next.customID)
+		}
+		seen[next.customID] = true
+
+		switch {
+		case prompt != nil:
+			var text strings.Builder
+			if err := prompt.Execute(&text, value); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+			}
+			next.text = strings.TrimSpace(text.String())
+		case isObject:
+			next.text, _ = fields["prompt"].(string)
+		default:
+			next.text, _ = value.(string)
+		}
+		if strings.TrimSpace(next.text) == "" {
+			return nil, fmt.Errorf("%s:%d: expected a prompt, as a string or an object with a \"prompt\"", path, line)
+		}
+		prompts = append(prompts, next)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(prompts) == 0 {
+		return nil, fmt.Errorf("%s: no prompts", path)
+	}
+	return prompts, nil
+}
+
+// Shows where the named batches are at, or every batch submitted from here.  Only those that have not ended are
+// asked after again.
+func batchStatus(ctx context.Context, bridge *anthropicbridge.AnthropicBridge, ids []string) error {
+	batches := make([]*figaro.Batch, 0, len(ids))
+	if len(ids) == 0 {
+		var err error
+		if batches, err = figaro.ListBatches(); err != nil {
+			return err
+		}
+	}
+	for _, id := range ids {
+		batch, err := figaro.LoadBatch(id)
+		if err != nil {
+			return err
+		}
+		batches = append(batches, batch)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSUBMITTED\tSTATUS\tREQUESTS\tSUCCEEDED\tFAILED\tMODEL\tINPUT")
+	for _, batch := range batches {
+		if batch.Status != string(anthropic.MessageBatchProcessingStatusEnded) {
+			if err := refreshBatch(ctx, bridge, batch); err != nil {
+				return err
+			}
+		}
+		counts := batch.Counts
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%s\t%s\n", batch.ID, batch.SubmittedAt.Format("2006-01-02 15:04"),
+			batch.Status, len(batch.CustomIDs), counts.Succeeded, counts.Errored+counts.Canceled+counts.Expired,
+			batch.Model, batch.Input)
+	}
+	return w.Flush()
+}
+
+// Asks the API where the batch is at, and keeps the answer.
+func refreshBatch(ctx context.Context, bridge *anthropicbridge.AnthropicBridge, batch *figaro.Batch) error {
+	status, err := bridge.GetBatch(ctx, batch.ID)
+	if err != nil {
+		return &figaro.ModelError{Err: fmt.Errorf("batch %s: %w", batch.ID, err)}
+	}
+	batch.Status = string(status.ProcessingStatus)
+	batch.Counts = status.RequestCounts
+	return batch.Save()
+}
+
+const batchResultMissing = "missing"
+
+// One line of the results file.
+type batchResult struct {
+	CustomID string `json:"custom_id"`
+	// succeeded, errored, canceled or expired, or missing if the API had no result for the prompt
+	Status     string                      `json:"status"`
+	Text       string                      `json:"text,omitempty"`
+	StopReason anthropic.MessageStopReason `json:"stop_reason,omitempty"`
+	Usage      *anthropic.Usage            `json:"usage,omitempty"`
+	Error      string                      `json:"error,omitempty"`
+}
+
+// Writes the results of an ended batch, the latest one unless named, as a line of json each, in the order of the
+// prompts.  They go next to the prompts file unless -o says otherwise.
+func batchResults(ctx context.Context, bridge *anthropicbridge.AnthropicBridge, args []string) error {
+	flags := flag.NewFlagSet("batch results", flag.ContinueOnError)
+	outputPath := flags.String("o", "", "Write the results to `file`, or to stdout if '-'")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		return errors.New("usage: figaro batch results [-o file] [batch id]")
+	}
+
+	var batch *figaro.Batch
+	if len(args) == 1 {
+		batch, err = figaro.LoadBatch(args[0])
+	} else if batch, err = figaro.LatestBatch(); errors.Is(err, figaro.ErrBatchNotFound) {
+		return errors.New("no batch submitted yet")
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := refreshBatch(ctx, bridge, batch); err != nil {
+		return err
+	}
+	if batch.Status != string(anthropic.MessageBatchProcessingStatusEnded) {
+		return fmt.Errorf("batch %s is %s, with %d of %d requests still processing", batch.ID, batch.Status,
+			batch.Counts.Processing, len(batch.CustomIDs))
+	}
+
+	results := make(map[string]batchResult, len(batch.CustomIDs))
+	err = bridge.BatchResults(ctx, batch.ID, func(response anthropic.MessageBatchIndividualResponse) error {
+		results[response.CustomID] = toBatchResult(response)
+		return nil
+	})
+	if err != nil {
+		return &figaro.ModelError{Err: fmt.Errorf("batch %s: %w", batch.ID, err)}
+	}
+
+	path := *outputPath
+	if path == "" {
+		path = strings.TrimSuffix(batch.Input, filepath.Ext(batch.Input)) + ".results.jsonl"
+	}
+	var out io.Writer = os.Stdout
+	if path != "-" {
+		file, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+	// every prompt gets a line, those the API has no result for too
+	encoder := json.NewEncoder(out)
+	missing := 0
+	for _, customID := range batch.CustomIDs {
+		result, ok := results[customID]
+		if !ok {
+			result = batchResult{CustomID: customID, Status: batchResultMissing}
+			missing++
+		}
+		if err := encoder.Encode(result); err != nil {
+			return err
+		}
+	}
+
+	if missing > 0 {
+		fmt.Fprintf(os.Stderr, "warning: %d of %d prompts have no result\n", missing, len(batch.CustomIDs))
+	}
+	if path != "-" {
+		batch.ResultsPath = path
+		if err := batch.Save(); err != nil {
+			return err
+		}
+		fmt.Printf("wrote %d results to %s\n", len(batch.CustomIDs)-missing, path)
+	}
+	return nil
+}
+
+func toBatchResult(response anthropic.MessageBatchIndividualResponse) batchResult {
+	result := batchResult{CustomID: response.CustomID, Status: response.Result.Type}
+	switch variant := response.Result.AsAny().(type) {
+	case anthropic.MessageBatchSucceededResult:
+		texts := make([]string, 0, len(variant.Message.Content))
+		for _, block := range variant.Message.Content {
+			if text, ok := block.AsAny().(anthropic.TextBlock); ok {
+				texts = append(texts, text.Text)
+			}
+		}
+		result.Text = strings.Join(texts, "\n")
+		result.StopReason = variant.Message.StopReason
+		result.Usage = &variant.Message.Usage
+	case anthropic.MessageBatchErroredResult:
+		result.Error = fmt.Sprintf("%s: %s", variant.Error.Error.Type, variant.Error.Error.Message)
+	}
+	return result
+}
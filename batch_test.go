@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"figaro/config"
+	"figaro/figaro"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// A stand-in for the Message Batches API that takes one batch and ends it at once.
+func newBatchServer(t *testing.T, longText string) *httptest.Server {
+	t.Helper()
+	batch := func(status string, succeeded int) string {
+		return fmt.Sprintf(`{"id":"msgbatch_test","type":"message_batch","processing_status":%q,
+			"request_counts":{"processing":%d,"succeeded":%d,"errored":1,"canceled":0,"expired":0},
+			"created_at":"2026-01-01T00:00:00Z","expires_at":"2026-01-02T00:00:00Z"}`, status, 3-succeeded, succeeded)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/v1/messages/batches":
+			var body struct {
+				Requests []struct {
+					CustomID string         `json:"custom_id"`
+					Params   map[string]any `json:"params"`
+				} `json:"requests"`
+			}
+			if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+				t.Errorf("failed to decode the batch: %v", err)
+			}
+			ids := make([]string, 0, len(body.Requests))
+			for _, request := range body.Requests {
+				ids = append(ids, request.CustomID)
+				if _, ok := request.Params["tools"]; ok {
+					t.Errorf("%s was sent with tools", request.CustomID)
+				}
+			}
+			if got := strings.Join(ids, ","); got != "ada,bob,line-3" {
+				t.Errorf("custom ids = %s", got)
+			}
+			messages, _ := json.Marshal(body.Requests[0].Params["messages"])
+			if !strings.Contains(string(messages), "Greet Ada.") {
+				t.Errorf("the template was not filled in: %s", messages)
+			}
+			fmt.Fprint(w, batch("in_progress", 0))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/messages/batches/msgbatch_test":
+			fmt.Fprint(w, batch("ended", 1))
+		case r.Method == http.MethodGet && r.URL.Path == "/v1/messages/batches/msgbatch_test/results":
+			w.Header().Set("Content-Type", "application/x-jsonl")
+			// out of order, and with nothing for line-3
+			fmt.Fprintln(w, `{"custom_id":"bob","result":{"type":"errored","error":{"type":"error",`+
+				`"error":{"type":"invalid_request_error","message":"bad prompt"}}}}`)
+			fmt.Fprintf(w, `{"custom_id":"ada","result":{"type":"succeeded","message":{"id":"msg_1","type":"message",`+
+				`"role":"assistant","model":"claude","content":[{"type":"text","text":%q}],"stop_reason":"end_turn",`+
+				`"usage":{"input_tokens":5,"output_tokens":7}}}}`+"\n", longText)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestBatchCommands(t *testing.T) {
+	// longer than the 64KB lines the sdk's results stream can read
+	longText := "Hello Ada. " + strings.Repeat("a", 100*1024)
+	server := newBatchServer(t, longText)
+	dir := t.TempDir()
+	t.Setenv("HOME", dir)
+	t.Setenv("ANTHROPIC_API_KEY", "test")
+	t.Setenv("ANTHROPIC_BASE_URL", server.URL)
+
+	prompts := filepath.Join(dir, "prompts.jsonl")
+	template := filepath.Join(dir, "greet.md")
+	write := func(path string, contents string) {
+		if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(prompts, `{"custom_id": "ada", "name": "Ada"}`+"\n"+`{"custom_id": "bob", "name": "Bob"}`+"\n"+`{"name": "Cy"}`+"\n")
+	write(template, "Greet {{.name}}.\n")
+
+	ctx := context.Background()
+	tp := noop.NewTracerProvider()
+	cfg := &config.Config{}
+	run := func(args ...string) {
+		t.Helper()
+		if err := runBatchCommand(ctx, tp, cfg, args); err != nil {
+			t.Fatalf("batch %s: %v", strings.Join(args, " "), err)
+		}
+	}
+
+	run("submit", "--template", template, prompts)
+	batch, err := figaro.LatestBatch()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if batch.ID != "msgbatch_test" || batch.Status != "in_progress" || len(batch.CustomIDs) != 3 {
+		t.Errorf("kept batch %s, %s, with %d prompts", batch.ID, batch.Status, len(batch.CustomIDs))
+	}
+
+	run("status")
+	if batch, err = figaro.LoadBatch("msgbatch_test"); err != nil {
+		t.Fatal(err)
+	}
+	if batch.Status != "ended" || batch.Counts.Succeeded != 1 {
+		t.Errorf("status %s with %d succeeded, want it updated", batch.Status, batch.Counts.Succeeded)
+	}
+
+	run("results")
+	file, err := os.Open(filepath.Join(dir, "prompts.results.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	results := make([]batchResult, 0)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for scanner.Scan() {
+		var result batchResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, result)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct{ customID, status string }{{"ada", "succeeded"}, {"bob", "errored"}, {"line-3", "missing"}}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for i, result := range results {
+		if result.CustomID != want[i].customID || result.Status != want[i].status {
+			t.Errorf("result %d = %s %s, want %s %s", i, result.CustomID, result.Status, want[i].customID, want[i].status)
+		}
+	}
+	if results[0].Text != longText || results[0].Usage == nil || results[0].Usage.OutputTokens != 7 {
+		t.Errorf("the succeeded result lost its text or usage")
+	}
+	if results[1].Error != "invalid_request_error: bad prompt" {
+		t.Errorf("error = %q", results[1].Error)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"figaro/config"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// A subcommand runs in place of a prompt when its name is the first argument, e.g. `figaro sessions list`.
+type command struct {
+	description string
+	run         func(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error
+}
+
+var commands = map[string]command{
+	"batch": {
+		description: "submit prompts to the Message Batches API, check on them and fetch the results",
+		run:         runBatchCommand,
+	},
+	"config": {
+		description: "show the merged configuration, or validate the config files",
+		run:         runConfigCommand,
+	},
+	"models": {
+		description: "list the models and aliases -m accepts",
+		run:         runModelsCommand,
+	},
+	"servers": {
+		description: "list, add, remove, enable, disable or test MCP servers",
+		run:         runServersCommand,
+	},
+	"sessions": {
+		description: "list, show or delete saved sessions",
+		run:         runSessionsCommand,
+	},
+	"tools": {
+		description: "list the tools of the MCP servers, or call one directly",
+		run:         runToolsCommand,
+	},
+	"usage": {
+		description: "report the tokens used and what they cost, by day and by model",
+		run:         runUsageCommand,
+	},
+}
+
+// Splits the first argument off as the name of a nested action, e.g. "list" in `figaro sessions list`.
+func subcommand(args []string, actions ...string) (string, []string, error) {
+	if len(args) == 0 {
+		return "", nil, fmt.Errorf("expected one of: %s", strings.Join(actions, ", "))
+	}
+	for _, action := range actions {
+		if args[0] == action {
+			return action, args[1:], nil
+		}
+	}
+	return "", nil, fmt.Errorf("unknown action %q, expected one of: %s", args[0], strings.Join(actions, ", "))
+}
+
+func commandNames() []string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: figaro [flags] [prompt...]\n       figaro <command> [args...]\n\nCommands:\n")
+		for _, name := range commandNames() {
+			fmt.Fprintf(out, "  %-12s %s\n", name, commands[name].description)
+		}
+		fmt.Fprintf(out, "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"figaro/config"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func runConfigCommand(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	action, args, err := subcommand(args, "show", "validate")
+	if err != nil {
+		return err
+	}
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "show":
+		return showConfig(os.Stdout, workingDir, cfg)
+	default:
+		return validateConfig(workingDir, cfg)
+	}
+}
+
+// Prints the merged configuration to out, with its secrets redacted, and the layers it was merged from to stderr.
+func showConfig(out io.Writer, workingDir string, cfg *config.Config) error {
+	if cfg == nil {
+		// Load already failed once, do it again for the error
+		_, err := config.Load(workingDir)
+		return fmt.Errorf("%w\nrun 'figaro config validate' for details", err)
+	}
+
+	layers, err := config.FileLayers(workingDir)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(os.Stderr, "# merged from, lowest precedence first:")
+	for _, layer := range layers {
+		fmt.Fprintf(os.Stderr, "#   %s\n", layer.Source)
+	}
+	for _, variable := range config.EnvironmentVariables {
+		if _, ok := os.LookupEnv(variable.Name); ok {
+			fmt.Fprintf(os.Stderr, "#   $%s\n", variable.Name)
+		}
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(cfg.Redacted())
+}
+
+// Prints every problem found in the configuration, one per line.
+func validateConfig(workingDir string, cfg *config.Config) error {
+	issues, err := config.Validate(workingDir)
+	if err != nil {
+		return err
+	}
+	if cfg != nil {
+		issues = append(issues, validateModels(workingDir, cfg)...)
+	}
+
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+	if len(issues) > 0 {
+		return fmt.Errorf("found %d problem(s) in the configuration", len(issues))
+	}
+	if cfg == nil {
+		return errors.New("the configuration could not be loaded")
+	}
+	fmt.Println("configuration is valid")
+	return nil
+}
+
+// Checks that the configured model is one -m would accept.  That takes the model list, which the config package
+// does not know about.
+func validateModels(workingDir string, cfg *config.Config) []config.Issue {
+	if cfg.Model == "" {
+		return nil
+	}
+	_, err := resolveModel(cfg, cfg.Model)
+	if err == nil {
+		return nil
+	}
+
+	issue := config.Issue{Source: "$FIGARO_MODEL", Path: "model", Message: err.Error()}
+	if _, ok := os.LookupEnv("FIGARO_MODEL"); ok {
+		return []config.Issue{issue}
+	}
+
+	// blame the last file to set it
+	layers, _ := config.FileLayers(workingDir)
+	for i := len(layers) - 1; i >= 0; i-- {
+		if line, column := layers[i].Position("model"); line > 0 {
+			issue.Source, issue.Line, issue.Column = layers[i].Source, line, column
+			break
+		}
+	}
+	return []config.Issue{issue}
+}
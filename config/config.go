@@ -0,0 +1,480 @@
+package config
+
+import (
+	"encoding/json"
+	"figaro/dockerbridge"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Everything figaro can be configured with.  It is merged from several layers, see Load.
+type Config struct {
+	// Model used when -m is not provided.  May be a model id, an sdk constant name or an alias.
+	Model string `json:"model,omitempty"`
+	// User-defined shorthands for models, e.g. "fast": "claude-3-5-haiku-latest".
+	Aliases map[string]string `json:"aliases,omitempty"`
+	// Sampling settings sent with every request.
+	Generation Generation `json:"generation,omitzero"`
+	// MCP servers by name.
+	Servers map[string]Server `json:"servers,omitempty"`
+	// Unnamed servers, as listed by the original ~/.figaro/servers.json.  Folded into Servers once loaded.
+	DockerServers []dockerbridge.ContainerDefinition `json:"docker_servers,omitempty"`
+	Limits        Limits                             `json:"limits,omitzero"`
+	Compaction    Compaction                         `json:"compaction,omitzero"`
+	Logging       Logging                            `json:"logging,omitzero"`
+	// What models cost, by model id, on top of DefaultPrices.
+	Prices map[string]Price `json:"prices,omitempty"`
+	// Model APIs other than Anthropic's, by name.  Their models are chosen as name:model, e.g. ollama:llama3.1.
+	Providers map[string]Provider `json:"providers,omitempty"`
+}
+
+const ProviderOpenAI = "openai"
+
+// A model API that speaks the OpenAI chat completions protocol, such as Ollama, vLLM or OpenAI itself.
+type Provider struct {
+	// The protocol spoken, only "openai" for now.
+	Type string `json:"type"`
+	// Where the API lives, up to and including the version, e.g. http://localhost:11434/v1.
+	BaseURL string `json:"base_url"`
+	// Sent as a bearer token, if set.
+	APIKey string `json:"api_key,omitempty"`
+}
+
+const DefaultMaxTokens = 4096
+
+// Sampling settings.  Unset values are left to the API's defaults, except for MaxTokens which is required.
+type Generation struct {
+	MaxTokens     int64    `json:"max_tokens,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"top_p,omitempty"`
+	TopK          *int64   `json:"top_k,omitempty"`
+	StopSequences []string `json:"stop_sequences,omitempty"`
+	// How many times a reply cut off by max_tokens is continued automatically.  0 leaves it cut off.
+	MaxContinuations int `json:"max_continuations,omitempty"`
+	// Tokens the model may think for before each reply, on top of MaxTokens.  0 leaves extended thinking off.
+	ThinkingBudget int64 `json:"thinking_budget,omitempty"`
+}
+
+// The smallest thinking budget the API takes.
+const MinThinkingBudget = 1024
+
+// Something wrong with one of the generation settings, named by its json key.
+type GenerationProblem struct {
+	Field   string
+	Message string
+}
+
+// What Generation.Validate found wrong.
+type GenerationError struct {
+	Problems []GenerationProblem
+}
+
+func (e *GenerationError) Error() string {
+	problems := make([]string, 0, len(e.Problems))
+	for _, problem := range e.Problems {
+		problems = append(problems, problem.Field+" "+problem.Message)
+	}
+	return fmt.Sprintf("invalid generation settings: %s", strings.Join(problems, "; "))
+}
+
+// Validate checks the settings requests are sent with, returning a *GenerationError if any is wrong.  Layer.Validate
+// checks those of each file, but flags are not in any file and settings from different files may not go together,
+// so they are checked again once merged.
+func (g Generation) Validate() error {
+	problems := make([]GenerationProblem, 0)
+	problem := func(field string, format string, args ...any) {
+		problems = append(problems, GenerationProblem{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if g.MaxTokens < 0 {
+		problem("max_tokens", "must be positive")
+	}
+	if t := g.Temperature; t != nil && (*t < 0 || *t > 1) {
+		problem("temperature", "must be between 0 and 1")
+	}
+	if p := g.TopP; p != nil && (*p < 0 || *p > 1) {
+		problem("top_p", "must be between 0 and 1")
+	}
+	if g.MaxContinuations < 0 {
+		problem("max_continuations", "must not be negative")
+	}
+	if b := g.ThinkingBudget; b != 0 && b < MinThinkingBudget {
+		problem("thinking_budget", "must be at least %d tokens", MinThinkingBudget)
+	}
+	// what the API allows with extended thinking
+	if g.ThinkingBudget > 0 {
+		if t := g.Temperature; t != nil && *t != 1 {
+			problem("temperature", "cannot be changed while thinking")
+		}
+		if g.TopK != nil {
+			problem("top_k", "cannot be set while thinking")
+		}
+		if p := g.TopP; p != nil && *p < 0.95 {
+			problem("top_p", "must be at least 0.95 while thinking")
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return &GenerationError{Problems: problems}
+}
+
+// What a model costs, in US dollars per million tokens.  Cache prices left at 0 are derived from the input price
+// the way the API charges them: writes at 1.25 times, reads at a tenth.
+type Price struct {
+	Input      float64 `json:"input"`
+	Output     float64 `json:"output"`
+	CacheWrite float64 `json:"cache_write,omitempty"`
+	CacheRead  float64 `json:"cache_read,omitempty"`
+}
+
+// The published prices of the models figaro knows about.
+var DefaultPrices = map[string]Price{
+	"claude-3-7-sonnet-latest":   {Input: 3, Output: 15},
+	"claude-3-7-sonnet-20250219": {Input: 3, Output: 15},
+	"claude-3-5-haiku-latest":    {Input: 0.8, Output: 4},
+	"claude-3-5-haiku-20241022":  {Input: 0.8, Output: 4},
+	"claude-3-5-sonnet-latest":   {Input: 3, Output: 15},
+	"claude-3-5-sonnet-20241022": {Input: 3, Output: 15},
+	"claude-3-5-sonnet-20240620": {Input: 3, Output: 15},
+	"claude-3-opus-latest":       {Input: 15, Output: 75},
+	"claude-3-opus-20240229":     {Input: 15, Output: 75},
+	"claude-3-sonnet-20240229":   {Input: 3, Output: 15},
+	"claude-3-haiku-20240307":    {Input: 0.25, Output: 1.25, CacheWrite: 0.3, CacheRead: 0.03},
+	"claude-2.1":                 {Input: 8, Output: 24},
+	"claude-2.0":                 {Input: 8, Output: 24},
+}
+
+// An MCP server run in a docker container.
+type Server struct {
+	dockerbridge.ContainerDefinition
+	Disabled bool `json:"disabled,omitempty"`
+	// Put in front of the server's tool names, as prefix__tool.  Defaults to the server's name, "" leaves the
+	// tool names alone.
+	ToolPrefix *string `json:"tool_prefix,omitempty"`
+}
+
+const (
+	DefaultRequestTimeout            = time.Minute
+	DefaultToolTimeout               = 10 * time.Second
+	DefaultMaxParallelTools          = 8
+	DefaultMaxParallelToolsPerServer = 4
+	DefaultMaxIterations             = 20
+	DefaultMaxToolCalls              = 50
+	DefaultMaxRetries                = 4
+	DefaultMaxRetryDelay             = time.Minute
+)
+
+type Limits struct {
+	// How long a request may take, tool calls included.
+	RequestTimeout Duration `json:"request_timeout,omitempty"`
+	// How long a single tool call may take.
+	ToolTimeout Duration `json:"tool_timeout,omitempty"`
+	// Overrides ToolTimeout for the named tools.
+	ToolTimeouts map[string]Duration `json:"tool_timeouts,omitempty"`
+	// How many tool calls may run at once, over all servers and on any one server.
+	MaxParallelTools          int `json:"max_parallel_tools,omitempty"`
+	MaxParallelToolsPerServer int `json:"max_parallel_tools_per_server,omitempty"`
+
+	// Budgets for one request.  Once one is spent, no more tools are called and the model is asked to sum up.
+	// How many rounds of tool calls the model may go through.
+	MaxIterations int `json:"max_iterations,omitempty"`
+	// How many tools may be called in all.
+	MaxToolCalls int `json:"max_tool_calls,omitempty"`
+	// How many tokens may be sent and received over every turn, cached ones included.  Unlimited if unset.
+	MaxInputTokens  int64 `json:"max_input_tokens,omitempty"`
+	MaxOutputTokens int64 `json:"max_output_tokens,omitempty"`
+	// How long the tool loop may go on.  Unlike RequestTimeout, this leaves the model time to sum up.  Unlimited
+	// if unset.
+	MaxDuration Duration `json:"max_duration,omitempty"`
+
+	// How many times a request the API turns down as rate limited or overloaded is tried again, and how long
+	// may be spent waiting between the attempts in all.  0 retries leaves it to fail.
+	MaxRetries    *int     `json:"max_retries,omitempty"`
+	MaxRetryDelay Duration `json:"max_retry_delay,omitempty"`
+}
+
+const (
+	DefaultContextWindow = 200_000
+	DefaultCompactAt     = 0.8
+	DefaultKeepMessages  = 6
+)
+
+// The context windows of the models figaro knows about that differ from DefaultContextWindow.
+var DefaultContextWindows = map[string]int64{
+	"claude-2.0": 100_000,
+}
+
+// When the conversation is compacted to stay within the model's context window.
+type Compaction struct {
+	Disabled bool `json:"disabled,omitempty"`
+	// Fraction of the context window the prompt may fill before older turns are compacted.
+	CompactAt float64 `json:"compact_at,omitempty"`
+	// How many of the latest messages are always kept as they are.
+	KeepMessages int `json:"keep_messages,omitempty"`
+	// Context windows in tokens by model id, on top of DefaultContextWindows.
+	ContextWindows map[string]int64 `json:"context_windows,omitempty"`
+}
+
+// Where traces are written and how the file is rotated.  Unset values keep the logging package's defaults.
+type Logging struct {
+	Path       string `json:"path,omitempty"`
+	MaxSizeMB  int    `json:"max_size_mb,omitempty"`
+	MaxAgeDays int    `json:"max_age_days,omitempty"`
+	MaxBackups int    `json:"max_backups,omitempty"`
+	Compress   *bool  `json:"compress,omitempty"`
+}
+
+// A time.Duration written as a string such as "90s" or "2m".
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected a duration such as \"90s\"")
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// GetRequestTimeout returns the configured request timeout, or the default.
+func (l Limits) GetRequestTimeout() time.Duration {
+	if l.RequestTimeout <= 0 {
+		return DefaultRequestTimeout
+	}
+	return time.Duration(l.RequestTimeout)
+}
+
+// GetToolTimeout returns the configured tool call timeout, or the default.
+func (l Limits) GetToolTimeout() time.Duration {
+	if l.ToolTimeout <= 0 {
+		return DefaultToolTimeout
+	}
+	return time.Duration(l.ToolTimeout)
+}
+
+// GetToolTimeoutFor returns how long a call to the named tool may take.
+func (l Limits) GetToolTimeoutFor(toolName string) time.Duration {
+	if timeout, ok := l.ToolTimeouts[toolName]; ok && timeout > 0 {
+		return time.Duration(timeout)
+	}
+	return l.GetToolTimeout()
+}
+
+// GetMaxParallelTools returns the configured limit on concurrent tool calls, or the default.
+func (l Limits) GetMaxParallelTools() int {
+	if l.MaxParallelTools <= 0 {
+		return DefaultMaxParallelTools
+	}
+	return l.MaxParallelTools
+}
+
+// GetMaxParallelToolsPerServer returns the configured limit on concurrent tool calls to one server, or the default.
+func (l Limits) GetMaxParallelToolsPerServer() int {
+	if l.MaxParallelToolsPerServer <= 0 {
+		return DefaultMaxParallelToolsPerServer
+	}
+	return l.MaxParallelToolsPerServer
+}
+
+// GetMaxIterations returns the configured limit on rounds of tool calls, or the default.
+func (l Limits) GetMaxIterations() int {
+	if l.MaxIterations <= 0 {
+		return DefaultMaxIterations
+	}
+	return l.MaxIterations
+}
+
+// GetMaxToolCalls returns the configured limit on tool calls per request, or the default.
+func (l Limits) GetMaxToolCalls() int {
+	if l.MaxToolCalls <= 0 {
+		return DefaultMaxToolCalls
+	}
+	return l.MaxToolCalls
+}
+
+// GetMaxRetries returns how many times a rate limited or overloaded request is retried.
+func (l Limits) GetMaxRetries() int {
+	if l.MaxRetries == nil {
+		return DefaultMaxRetries
+	}
+	return *l.MaxRetries
+}
+
+// GetMaxRetryDelay returns how long may be spent waiting to retry a request, over every attempt.
+func (l Limits) GetMaxRetryDelay() time.Duration {
+	if l.MaxRetryDelay <= 0 {
+		return DefaultMaxRetryDelay
+	}
+	return time.Duration(l.MaxRetryDelay)
+}
+
+// GetContextWindow returns how many tokens the model takes in, as configured, known or assumed.
+func (c Compaction) GetContextWindow(model string) int64 {
+	if window, ok := c.ContextWindows[model]; ok && window > 0 {
+		return window
+	}
+	if window, ok := DefaultContextWindows[model]; ok {
+		return window
+	}
+	return DefaultContextWindow
+}
+
+// GetCompactAt returns the configured fraction of the context window that triggers compaction, or the default.
+func (c Compaction) GetCompactAt() float64 {
+	if c.CompactAt <= 0 || c.CompactAt > 1 {
+		return DefaultCompactAt
+	}
+	return c.CompactAt
+}
+
+// GetKeepMessages returns the configured number of messages left out of compaction, or the default.
+func (c Compaction) GetKeepMessages() int {
+	if c.KeepMessages <= 0 {
+		return DefaultKeepMessages
+	}
+	return c.KeepMessages
+}
+
+// SplitModel tells which of providers serves model, when it is named provider:model, and the name of the model
+// there.  Models of no provider are Anthropic's.
+func SplitModel(model string, providers map[string]Provider) (string, string, bool) {
+	provider, name, found := strings.Cut(model, ":")
+	if _, ok := providers[provider]; !found || !ok {
+		return "", model, false
+	}
+	return provider, name, true
+}
+
+// GetPrices returns the price of every model with one, the configured ones taking precedence over DefaultPrices.
+func (c *Config) GetPrices() map[string]Price {
+	prices := make(map[string]Price, len(DefaultPrices)+len(c.Prices))
+	for model, price := range DefaultPrices {
+		prices[model] = price
+	}
+	for model, price := range c.Prices {
+		prices[model] = price
+	}
+	return prices
+}
+
+// Cost returns what the tokens cost, in US dollars.
+func (p Price) Cost(input int64, output int64, cacheWrite int64, cacheRead int64) float64 {
+	cacheWritePrice, cacheReadPrice := p.CacheWrite, p.CacheRead
+	if cacheWritePrice == 0 {
+		cacheWritePrice = p.Input * 1.25
+	}
+	if cacheReadPrice == 0 {
+		cacheReadPrice = p.Input / 10
+	}
+	cost := float64(input)*p.Input + float64(output)*p.Output +
+		float64(cacheWrite)*cacheWritePrice + float64(cacheRead)*cacheReadPrice
+	return cost / 1e6
+}
+
+// EnabledServers returns the names of the servers that are not disabled, sorted.
+func (c *Config) EnabledServers() []string {
+	names := make([]string, 0, len(c.Servers))
+	for name, server := range c.Servers {
+		if !server.Disabled {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Moves the unnamed servers of the legacy format into Servers, named after their container or image.
+func (c *Config) foldDockerServers() {
+	if len(c.DockerServers) == 0 {
+		return
+	}
+	if c.Servers == nil {
+		c.Servers = make(map[string]Server, len(c.DockerServers))
+	}
+	for _, def := range c.DockerServers {
+		name := LegacyServerName(def)
+		// a named entry with nothing but settings, such as disabled, applies to the legacy server of that name
+		if server, exists := c.Servers[name]; !exists || !server.IsDefined() {
+			server.ContainerDefinition = def
+			c.Servers[name] = server
+		}
+	}
+	c.DockerServers = nil
+}
+
+// Stands in for a secret in Redacted.
+const RedactedValue = "[redacted]"
+
+// Redacted returns a copy of the configuration fit to be shown, with the providers' api keys and the values of the
+// servers' environment variables, which ${VAR} may well have filled in with secrets, replaced by RedactedValue.
+func (c Config) Redacted() *Config {
+	if c.Providers != nil {
+		providers := make(map[string]Provider, len(c.Providers))
+		for name, provider := range c.Providers {
+			if provider.APIKey != "" {
+				provider.APIKey = RedactedValue
+			}
+			providers[name] = provider
+		}
+		c.Providers = providers
+	}
+	if c.Servers != nil {
+		servers := make(map[string]Server, len(c.Servers))
+		for name, server := range c.Servers {
+			if server.Env != nil {
+				env := make([]string, 0, len(*server.Env))
+				for _, variable := range *server.Env {
+					key, _, _ := strings.Cut(variable, "=")
+					env = append(env, key+"="+RedactedValue)
+				}
+				server.Env = &env
+			}
+			servers[name] = server
+		}
+		c.Servers = servers
+	}
+	return &c
+}
+
+// GetToolPrefix returns what the tools of the server called name are prefixed with.
+func (s Server) GetToolPrefix(name string) string {
+	if s.ToolPrefix == nil {
+		return name
+	}
+	return *s.ToolPrefix
+}
+
+// IsDefined reports whether the server says which container to run.
+func (s Server) IsDefined() bool {
+	return s.ID != nil || s.ContainerName != nil || s.ImageName != nil
+}
+
+// LegacyServerName names a server listed without one, after its container, image or id.
+func LegacyServerName(def dockerbridge.ContainerDefinition) string {
+	switch {
+	case def.ContainerName != nil:
+		return *def.ContainerName
+	case def.ImageName != nil:
+		// mcp/brave-search:latest -> brave-search
+		name := path.Base(*def.ImageName)
+		if idx := strings.LastIndex(name, ":"); idx != -1 {
+			name = name[:idx]
+		}
+		return name
+	case def.ID != nil:
+		return *def.ID
+	default:
+		return "unnamed"
+	}
+}
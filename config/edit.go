@@ -0,0 +1,150 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// Edit applies edit to the settings in the config file at path and writes them back, creating the file if need
+// be.  Settings edit does not touch are kept, though not their formatting.
+func Edit(path string, edit func(values map[string]any) error) error {
+	layer := &Layer{Source: path}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	layer.Data = data
+	if err := layer.parse(); err != nil {
+		return err
+	}
+
+	if err := edit(layer.values); err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(layer.values, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0600)
+}
+
+// Server names become part of tool names, so they are held to the same characters.
+var serverNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,32}$`)
+
+// ValidateServerName rejects names that could not prefix a tool name.
+func ValidateServerName(name string) error {
+	if !serverNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid server name %q: use up to 32 letters, digits, '-' or '_'", name)
+	}
+	return nil
+}
+
+// Servers returns the servers object of a config file, adding it if it is missing.
+func Servers(values map[string]any) (map[string]any, error) {
+	servers, ok := values["servers"]
+	if !ok {
+		servers = map[string]any{}
+		values["servers"] = servers
+	}
+	serverMap, ok := servers.(map[string]any)
+	if !ok {
+		return nil, errors.New("servers is not an object")
+	}
+	return serverMap, nil
+}
+
+// SetServer adds or replaces the server called name.  Unset fields are left out rather than written as null.
+func SetServer(values map[string]any, name string, server Server) error {
+	servers, err := Servers(values)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(server)
+	if err != nil {
+		return err
+	}
+	var entry map[string]any
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return err
+	}
+	for key, value := range entry {
+		if value == nil {
+			delete(entry, key)
+		}
+	}
+	servers[name] = entry
+	return nil
+}
+
+// RemoveServer deletes the server called name, reporting whether it was there.
+func RemoveServer(values map[string]any, name string) (bool, error) {
+	servers, err := Servers(values)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := servers[name]; !ok {
+		return false, nil
+	}
+	delete(servers, name)
+	return true, nil
+}
+
+// RemoveLegacyServer deletes the server that would be named name from the docker_servers list of the legacy
+// servers.json, reporting whether it was there.
+func RemoveLegacyServer(values map[string]any, name string) (bool, error) {
+	list, ok := values["docker_servers"].([]any)
+	if !ok {
+		return false, nil
+	}
+	kept := make([]any, 0, len(list))
+	for _, item := range list {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return false, err
+		}
+		var def Server
+		if err := json.Unmarshal(data, &def); err != nil {
+			return false, err
+		}
+		if LegacyServerName(def.ContainerDefinition) != name {
+			kept = append(kept, item)
+		}
+	}
+	values["docker_servers"] = kept
+	return len(kept) < len(list), nil
+}
+
+// SetServerDisabled switches the server called name off or on.  The server may be defined in another file, in
+// which case only the switch is written here and merged over it.
+func SetServerDisabled(values map[string]any, name string, disabled bool) error {
+	servers, err := Servers(values)
+	if err != nil {
+		return err
+	}
+	entry, ok := servers[name].(map[string]any)
+	if !ok {
+		entry = map[string]any{}
+		servers[name] = entry
+	}
+
+	definesServer := false
+	for _, key := range []string{"id", "image_name", "container_name"} {
+		if _, ok := entry[key]; ok {
+			definesServer = true
+		}
+	}
+	if !disabled && definesServer {
+		delete(entry, "disabled")
+	} else {
+		entry["disabled"] = disabled
+	}
+	return nil
+}
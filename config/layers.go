@@ -0,0 +1,262 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// One source of settings.  Later layers override earlier ones.
+type Layer struct {
+	// File the layer was read from, or a description such as "environment" for the other layers.
+	Source string
+	// Raw contents, kept so that problems can be reported by line.
+	Data   []byte
+	values map[string]any
+}
+
+// Load merges, from lowest to highest precedence:
+//   - the legacy ~/.figaro/servers.json
+//   - the global ~/.figaro/config.json
+//   - every .figaro/config.json from the filesystem root down to workingDir
+//   - FIGARO_* environment variables
+//   - overrides, which is where command line flags come in
+//
+// Objects are merged key by key, anything else is replaced.  ${VAR} references in strings are expanded from the
+// environment.
+func Load(workingDir string, overrides ...func(*Config)) (*Config, error) {
+	layers, err := FileLayers(workingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := map[string]any{}
+	for _, layer := range layers {
+		if err := layer.parse(); err != nil {
+			return nil, err
+		}
+		mergeValues(merged, layer.values)
+	}
+	mergeValues(merged, environmentValues())
+	expandValues(merged)
+
+	config, err := decode(merged)
+	if err != nil {
+		return nil, err
+	}
+	for _, override := range overrides {
+		override(config)
+	}
+	config.foldDockerServers()
+	return config, nil
+}
+
+// FileLayers lists the config files that exist, lowest precedence first.
+func FileLayers(workingDir string) ([]*Layer, error) {
+	paths, err := FilePaths(workingDir)
+	if err != nil {
+		return nil, err
+	}
+
+	layers := make([]*Layer, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		layers = append(layers, &Layer{Source: path, Data: data})
+	}
+	return layers, nil
+}
+
+// FilePaths lists where config files are looked for, lowest precedence first, whether they exist or not.
+func FilePaths(workingDir string) ([]string, error) {
+	globalDir, err := GlobalDir()
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{
+		filepath.Join(globalDir, "servers.json"),
+		filepath.Join(globalDir, "config.json"),
+	}
+
+	dir, err := filepath.Abs(workingDir)
+	if err != nil {
+		return nil, err
+	}
+	projectPaths := make([]string, 0)
+	for {
+		// the global directory is found again while walking up if we are somewhere under the home directory
+		if projectDir := filepath.Join(dir, ".figaro"); projectDir != globalDir {
+			projectPaths = append(projectPaths, filepath.Join(projectDir, "config.json"))
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	for i := len(projectPaths) - 1; i >= 0; i-- {
+		paths = append(paths, projectPaths[i])
+	}
+	return paths, nil
+}
+
+// GlobalDir returns ~/.figaro.
+func GlobalDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".figaro"), nil
+}
+
+// GlobalPath returns the location of the user's config file.
+func GlobalPath() (string, error) {
+	dir, err := GlobalDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config.json"), nil
+}
+
+func (layer *Layer) parse() error {
+	if layer.values != nil {
+		return nil
+	}
+	values := map[string]any{}
+	if len(bytes.TrimSpace(layer.Data)) > 0 {
+		if err := json.Unmarshal(layer.Data, &values); err != nil {
+			return fmt.Errorf("%s: %w", layer.Source, err)
+		}
+	}
+	layer.values = values
+	return nil
+}
+
+func decode(values map[string]any) (*Config, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+func mergeValues(dst map[string]any, src map[string]any) {
+	for key, value := range src {
+		srcMap, srcIsMap := value.(map[string]any)
+		dstMap, dstIsMap := dst[key].(map[string]any)
+		if srcIsMap && dstIsMap {
+			mergeValues(dstMap, srcMap)
+		} else {
+			dst[key] = value
+		}
+	}
+}
+
+var variablePattern = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)\}`)
+
+// Replaces ${VAR} with the value of the environment variable VAR, or nothing if it is not set.
+func expandValues(value any) any {
+	switch v := value.(type) {
+	case string:
+		return variablePattern.ReplaceAllStringFunc(v, func(reference string) string {
+			return os.Getenv(reference[2 : len(reference)-1])
+		})
+	case map[string]any:
+		for key, inner := range v {
+			v[key] = expandValues(inner)
+		}
+	case []any:
+		for i, inner := range v {
+			v[i] = expandValues(inner)
+		}
+	}
+	return value
+}
+
+// An environment variable overriding a single setting.
+type environmentVariable struct {
+	Name string
+	Path []string
+	// How the value is written in json
+	parse func(string) (any, error)
+}
+
+func asString(s string) (any, error) { return s, nil }
+func asNumber(s string) (any, error) { return strconv.ParseFloat(s, 64) }
+func asBool(s string) (any, error)   { return strconv.ParseBool(s) }
+func asDuration(s string) (any, error) {
+	_, err := time.ParseDuration(s)
+	return s, err
+}
+func asList(s string) (any, error) {
+	values := make([]any, 0)
+	for _, item := range strings.Split(s, ",") {
+		values = append(values, item)
+	}
+	return values, nil
+}
+
+var EnvironmentVariables = []environmentVariable{
+	{Name: "FIGARO_MODEL", Path: []string{"model"}, parse: asString},
+	{Name: "FIGARO_MAX_TOKENS", Path: []string{"generation", "max_tokens"}, parse: asNumber},
+	{Name: "FIGARO_TEMPERATURE", Path: []string{"generation", "temperature"}, parse: asNumber},
+	{Name: "FIGARO_TOP_P", Path: []string{"generation", "top_p"}, parse: asNumber},
+	{Name: "FIGARO_TOP_K", Path: []string{"generation", "top_k"}, parse: asNumber},
+	{Name: "FIGARO_THINKING_BUDGET", Path: []string{"generation", "thinking_budget"}, parse: asNumber},
+	{Name: "FIGARO_STOP_SEQUENCES", Path: []string{"generation", "stop_sequences"}, parse: asList},
+	{Name: "FIGARO_MAX_CONTINUATIONS", Path: []string{"generation", "max_continuations"}, parse: asNumber},
+	{Name: "FIGARO_REQUEST_TIMEOUT", Path: []string{"limits", "request_timeout"}, parse: asDuration},
+	{Name: "FIGARO_TOOL_TIMEOUT", Path: []string{"limits", "tool_timeout"}, parse: asDuration},
+	{Name: "FIGARO_MAX_PARALLEL_TOOLS", Path: []string{"limits", "max_parallel_tools"}, parse: asNumber},
+	{Name: "FIGARO_MAX_ITERATIONS", Path: []string{"limits", "max_iterations"}, parse: asNumber},
+	{Name: "FIGARO_MAX_TOOL_CALLS", Path: []string{"limits", "max_tool_calls"}, parse: asNumber},
+	{Name: "FIGARO_MAX_DURATION", Path: []string{"limits", "max_duration"}, parse: asDuration},
+	{Name: "FIGARO_MAX_RETRIES", Path: []string{"limits", "max_retries"}, parse: asNumber},
+	{Name: "FIGARO_LOG_PATH", Path: []string{"logging", "path"}, parse: asString},
+	{Name: "FIGARO_LOG_COMPRESS", Path: []string{"logging", "compress"}, parse: asBool},
+}
+
+// Collects the FIGARO_* variables that are set into the same shape as a config file.  Values that cannot be
+// parsed are skipped here and reported by Validate.
+func environmentValues() map[string]any {
+	values := map[string]any{}
+	for _, variable := range EnvironmentVariables {
+		raw, ok := os.LookupEnv(variable.Name)
+		if !ok {
+			continue
+		}
+		value, err := variable.parse(raw)
+		if err != nil {
+			continue
+		}
+		setPath(values, variable.Path, value)
+	}
+	return values
+}
+
+func setPath(values map[string]any, path []string, value any) {
+	for _, key := range path[:len(path)-1] {
+		inner, ok := values[key].(map[string]any)
+		if !ok {
+			inner = map[string]any{}
+			values[key] = inner
+		}
+		values = inner
+	}
+	values[path[len(path)-1]] = value
+}
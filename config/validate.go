@@ -0,0 +1,388 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A problem found in a layer of configuration.
+type Issue struct {
+	Source string
+	// 1-based, or 0 if the position is unknown
+	Line    int
+	Column  int
+	Path    string
+	Message string
+}
+
+func (issue Issue) String() string {
+	location := issue.Source
+	if issue.Line > 0 {
+		location = fmt.Sprintf("%s:%d:%d", issue.Source, issue.Line, issue.Column)
+	}
+	if issue.Path != "" {
+		return fmt.Sprintf("%s: %s: %s", location, issue.Path, issue.Message)
+	}
+	return fmt.Sprintf("%s: %s", location, issue.Message)
+}
+
+// Validate checks every config file found from workingDir, as well as the FIGARO_* environment variables, against
+// the schema of Config.  Returns an empty list if all is well.
+func Validate(workingDir string) ([]Issue, error) {
+	layers, err := FileLayers(workingDir)
+	if err != nil {
+		return nil, err
+	}
+	issues := make([]Issue, 0)
+	for _, layer := range layers {
+		issues = append(issues, layer.Validate()...)
+	}
+	issues = append(issues, validateEnvironment()...)
+	if len(issues) > 0 {
+		return issues, nil
+	}
+
+	// a file may only switch a server defined in another one on or off, or set the key of a provider defined
+	// elsewhere, so servers and providers are checked once merged, as are generation settings that do not go
+	// together
+	config, err := Load(workingDir)
+	if err != nil {
+		return nil, err
+	}
+	blame := func(issue Issue, path ...string) Issue {
+		for _, layer := range layers {
+			if line, column := layer.Position(path...); line > 0 {
+				issue.Source, issue.Line, issue.Column = layer.Source, line, column
+			}
+		}
+		return issue
+	}
+	for name, server := range config.Servers {
+		if !server.IsDefined() {
+			issue := Issue{Path: "servers." + name, Message: "needs one of image_name, container_name or id"}
+			issues = append(issues, blame(issue, "servers", name))
+		}
+	}
+	if err := config.Generation.Validate(); err != nil {
+		issues = append(issues, blame(Issue{Path: "generation", Message: err.Error()}, "generation"))
+	}
+	for name, provider := range config.Providers {
+		if provider.Type == "" || provider.BaseURL == "" {
+			issue := Issue{Path: "providers." + name, Message: "needs a type and a base_url"}
+			issues = append(issues, blame(issue, "providers", name))
+		}
+	}
+	sort.Slice(issues, func(i, j int) bool { return issues[i].Path < issues[j].Path })
+	return issues, nil
+}
+
+// Validate checks a single file against the schema of Config.
+func (layer *Layer) Validate() []Issue {
+	issue := func(path []string, format string, args ...any) Issue {
+		line, column := layer.Position(path...)
+		return Issue{
+			Source:  layer.Source,
+			Line:    line,
+			Column:  column,
+			Path:    strings.Join(path, "."),
+			Message: fmt.Sprintf(format, args...),
+		}
+	}
+
+	if err := layer.parse(); err != nil {
+		var syntaxError *json.SyntaxError
+		var typeError *json.UnmarshalTypeError
+		var offset int64
+		switch {
+		case errors.As(err, &syntaxError):
+			offset = syntaxError.Offset
+		case errors.As(err, &typeError):
+			offset = typeError.Offset
+		}
+		line, column := lineAndColumn(layer.Data, offset)
+		return []Issue{{Source: layer.Source, Line: line, Column: column, Message: errors.Unwrap(err).Error()}}
+	}
+
+	issues := make([]Issue, 0)
+	for _, problem := range checkSchema(nil, layer.values, reflect.TypeOf(Config{})) {
+		issues = append(issues, issue(problem.path, "%s", problem.message))
+	}
+	if len(issues) > 0 {
+		// the semantic checks below assume the layer is well formed
+		return issues
+	}
+
+	var config Config
+	if err := json.Unmarshal(layer.Data, &config); err != nil {
+		return append(issues, issue(nil, "%v", err))
+	}
+	var generationError *GenerationError
+	if err := config.Generation.Validate(); errors.As(err, &generationError) {
+		for _, problem := range generationError.Problems {
+			issues = append(issues, issue([]string{"generation", problem.Field}, "%s", problem.Message))
+		}
+	}
+	limits := map[string]int64{
+		"max_parallel_tools":            int64(config.Limits.MaxParallelTools),
+		"max_parallel_tools_per_server": int64(config.Limits.MaxParallelToolsPerServer),
+		"max_iterations":                int64(config.Limits.MaxIterations),
+		"max_tool_calls":                int64(config.Limits.MaxToolCalls),
+		"max_input_tokens":              config.Limits.MaxInputTokens,
+		"max_output_tokens":             config.Limits.MaxOutputTokens,
+	}
+	if r := config.Limits.MaxRetries; r != nil {
+		limits["max_retries"] = int64(*r)
+	}
+	for _, name := range slices.Sorted(maps.Keys(limits)) {
+		if limits[name] < 0 {
+			issues = append(issues, issue([]string{"limits", name}, "must not be negative"))
+		}
+	}
+	compaction := config.Compaction
+	if compaction.CompactAt < 0 || compaction.CompactAt > 1 {
+		issues = append(issues, issue([]string{"compaction", "compact_at"}, "must be between 0 and 1"))
+	}
+	if compaction.KeepMessages < 0 {
+		issues = append(issues, issue([]string{"compaction", "keep_messages"}, "must not be negative"))
+	}
+	for _, model := range slices.Sorted(maps.Keys(compaction.ContextWindows)) {
+		if compaction.ContextWindows[model] <= 0 {
+			issues = append(issues, issue([]string{"compaction", "context_windows", model}, "must be positive"))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(config.Providers)) {
+		if t := config.Providers[name].Type; t != "" && t != ProviderOpenAI {
+			issues = append(issues, issue([]string{"providers", name, "type"}, "must be %q", ProviderOpenAI))
+		}
+	}
+	for _, model := range slices.Sorted(maps.Keys(config.Prices)) {
+		price := config.Prices[model]
+		if price.Input < 0 || price.Output < 0 || price.CacheWrite < 0 || price.CacheRead < 0 {
+			issues = append(issues, issue([]string{"prices", model}, "prices must not be negative"))
+		}
+	}
+
+	for _, reference := range variablePattern.FindAllSubmatchIndex(layer.Data, -1) {
+		name := string(layer.Data[reference[2]:reference[3]])
+		if _, ok := os.LookupEnv(name); !ok {
+			line, column := lineAndColumn(layer.Data, int64(reference[0]))
+			issues = append(issues, Issue{
+				Source:  layer.Source,
+				Line:    line,
+				Column:  column,
+				Message: fmt.Sprintf("${%s} is not set in the environment and expands to nothing", name),
+			})
+		}
+	}
+	return issues
+}
+
+func validateEnvironment() []Issue {
+	issues := make([]Issue, 0)
+	for _, variable := range EnvironmentVariables {
+		raw, ok := os.LookupEnv(variable.Name)
+		if !ok {
+			continue
+		}
+		if _, err := variable.parse(raw); err != nil {
+			issues = append(issues, Issue{
+				Source:  "environment",
+				Path:    variable.Name,
+				Message: fmt.Sprintf("cannot set %s from %q: %v", strings.Join(variable.Path, "."), raw, err),
+			})
+		}
+	}
+	return issues
+}
+
+type schemaProblem struct {
+	path    []string
+	message string
+}
+
+var durationType = reflect.TypeOf(Duration(0))
+
+// Checks decoded json against the type it is meant to be decoded into, reporting every problem rather than the
+// first one as encoding/json would.
+func checkSchema(path []string, value any, t reflect.Type) []schemaProblem {
+	problem := func(format string, args ...any) []schemaProblem {
+		return []schemaProblem{{path: path, message: fmt.Sprintf(format, args...)}}
+	}
+	if value == nil {
+		return nil
+	}
+
+	if t == durationType {
+		s, ok := value.(string)
+		if !ok {
+			return problem("expected a duration such as \"90s\"")
+		}
+		if _, err := time.ParseDuration(s); err != nil {
+			return problem("%v", err)
+		}
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return checkSchema(path, value, t.Elem())
+	case reflect.Struct:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return problem("expected an object")
+		}
+		fields := jsonFields(t)
+		problems := make([]schemaProblem, 0)
+		for key, inner := range object {
+			field, ok := fields[key]
+			if !ok {
+				problems = append(problems, schemaProblem{path: appendPath(path, key), message: "unknown setting"})
+				continue
+			}
+			problems = append(problems, checkSchema(appendPath(path, key), inner, field)...)
+		}
+		return problems
+	case reflect.Map:
+		object, ok := value.(map[string]any)
+		if !ok {
+			return problem("expected an object")
+		}
+		problems := make([]schemaProblem, 0)
+		for key, inner := range object {
+			problems = append(problems, checkSchema(appendPath(path, key), inner, t.Elem())...)
+		}
+		return problems
+	case reflect.Slice:
+		list, ok := value.([]any)
+		if !ok {
+			return problem("expected a list")
+		}
+		problems := make([]schemaProblem, 0)
+		for i, inner := range list {
+			problems = append(problems, checkSchema(appendPath(path, strconv.Itoa(i)), inner, t.Elem())...)
+		}
+		return problems
+	case reflect.String:
+		if _, ok := value.(string); !ok {
+			return problem("expected a string")
+		}
+	case reflect.Bool:
+		if _, ok := value.(bool); !ok {
+			return problem("expected true or false")
+		}
+	case reflect.Int, reflect.Int64:
+		number, ok := value.(float64)
+		if !ok || number != float64(int64(number)) {
+			return problem("expected a whole number")
+		}
+	case reflect.Float64:
+		if _, ok := value.(float64); !ok {
+			return problem("expected a number")
+		}
+	}
+	return nil
+}
+
+// Maps the json names of a struct's fields to their types, embedded structs included.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			for name, inner := range jsonFields(field.Type) {
+				fields[name] = inner
+			}
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = field.Type
+	}
+	return fields
+}
+
+func appendPath(path []string, key string) []string {
+	return append(path[:len(path):len(path)], key)
+}
+
+// Position returns the line and column at which the value at path is written, or 0, 0 if it cannot be found.
+func (layer *Layer) Position(path ...string) (int, int) {
+	offset, ok := locate(layer.Data)[strings.Join(path, ".")]
+	if !ok {
+		return 0, 0
+	}
+	return lineAndColumn(layer.Data, offset)
+}
+
+// Records where each key and list element starts, by dotted path.
+func locate(data []byte) map[string]int64 {
+	positions := map[string]int64{}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	var walk func(path []string) error
+	walk = func(path []string) error {
+		offset := decoder.InputOffset()
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		if _, ok := positions[strings.Join(path, ".")]; !ok {
+			positions[strings.Join(path, ".")] = offset
+		}
+
+		switch token {
+		case json.Delim('{'):
+			for decoder.More() {
+				keyOffset := decoder.InputOffset()
+				key, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+				inner := appendPath(path, fmt.Sprint(key))
+				positions[strings.Join(inner, ".")] = keyOffset
+				if err := walk(inner); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		case json.Delim('['):
+			for i := 0; decoder.More(); i++ {
+				if err := walk(appendPath(path, strconv.Itoa(i))); err != nil {
+					return err
+				}
+			}
+			_, err = decoder.Token()
+		}
+		return err
+	}
+	walk(nil)
+	return positions
+}
+
+// Converts a byte offset into a 1-based line and column, skipping the separators a decoder offset may point at.
+func lineAndColumn(data []byte, offset int64) (int, int) {
+	for offset < int64(len(data)) && strings.ContainsRune(" \t\r\n,:", rune(data[offset])) {
+		offset++
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := 1 + bytes.Count(data[:offset], []byte("\n"))
+	column := int(offset) - bytes.LastIndexByte(data[:offset], '\n')
+	return line, column
+}
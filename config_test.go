@@ -0,0 +1,45 @@
+package main
+
+import (
+	"bytes"
+	"figaro/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestShowConfigRedactsSecrets(t *testing.T) {
+	const secret = "sk-very-secret"
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("FIGARO_TEST_SECRET", secret)
+	if err := os.MkdirAll(filepath.Join(home, ".figaro"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err := os.WriteFile(filepath.Join(home, ".figaro", "config.json"), []byte(`{
+		"providers": {"openai": {"type": "openai", "base_url": "https://api.openai.com/v1", "api_key": "${FIGARO_TEST_SECRET}"}},
+		"servers": {"github": {"image_name": "mcp/github", "env": ["GITHUB_TOKEN=${FIGARO_TEST_SECRET}"]}}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg, err := config.Load(home)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := showConfig(&out, home, cfg); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), secret) {
+		t.Errorf("config show printed the secret:\n%s", out.String())
+	}
+	if !strings.Contains(out.String(), "GITHUB_TOKEN="+config.RedactedValue) {
+		t.Errorf("config show left out which variables are set:\n%s", out.String())
+	}
+	if cfg.Providers["openai"].APIKey != secret {
+		t.Error("redacting changed the configuration in use")
+	}
+}
@@ -6,6 +6,8 @@ import (
 	"io"
 	"os"
 	"regexp"
+	"strings"
+	"time"
 
 	"figaro/jsonrpc"
 	"figaro/logging"
@@ -35,9 +37,14 @@ func (s ContainerDefinition) GetEnv() *[]string {
 	return s.Env
 }
 
-// Creates a json rpc connection object to the provided container definition
+// How long a container is given to stop on its own before it is killed.
+const stopTimeoutSeconds = 5
+
+// Creates a json rpc connection object to the provided container definition.  Once ctx ends, the connection is closed
+// and the container stopped, if it was started here, after which the returned channel is closed.
 // TODO: Attach lifecycle management to the docker container if possible.  I would at least like a channel when it goes offline.
 func Setup(ctx context.Context, def ContainerDefinition, tp trace.TracerProvider) (*jsonrpc.Connection, <-chan (error), error) {
+	lifetime := ctx
 	ctx, cancel := context.WithCancelCause(ctx)
 	defer cancel(ctx.Err())
 
@@ -62,7 +69,7 @@ func Setup(ctx context.Context, def ContainerDefinition, tp trace.TracerProvider
 		return nil, nil, err
 	}
 
-	id, err := server.getOrCreateContainer(ctx, cli)
+	id, started, err := server.getOrCreateContainer(ctx, cli)
 	if err != nil {
 		cli.Close()
 		cancel(err)
@@ -84,12 +91,22 @@ func Setup(ctx context.Context, def ContainerDefinition, tp trace.TracerProvider
 		return nil, nil, err
 	}
 
-	// Set up a goroutine to handle container output
-	outputDone := make(chan error)
+	// Tear down once the connection is no longer needed
+	outputDone := make(chan error, 1)
 	go func() {
-		<-ctx.Done()
+		defer close(outputDone)
+		<-lifetime.Done()
+		waiter.Close()
+		var err error
+		// containers that were already running are left that way
+		if started {
+			timeout := stopTimeoutSeconds
+			stopCtx, stopCancel := context.WithTimeout(context.WithoutCancel(lifetime), (stopTimeoutSeconds+5)*time.Second)
+			err = cli.ContainerStop(stopCtx, *id, container.StopOptions{Timeout: &timeout})
+			stopCancel()
+		}
 		cli.Close()
-		outputDone <- ctx.Err()
+		outputDone <- err
 	}()
 
 	return &jsonrpc.Connection{
@@ -137,7 +154,8 @@ func Setup(ctx context.Context, def ContainerDefinition, tp trace.TracerProvider
 //	    log.Println("Container stopped, performing cleanup...")
 //	}
 
-func (ctr *Container) getOrCreateContainer(ctx context.Context, cli *client.Client) (id *string, err error) {
+// Returns the id of the container, and whether it was started here.
+func (ctr *Container) getOrCreateContainer(ctx context.Context, cli *client.Client) (id *string, started bool, err error) {
 	ctx, span := ctr.Tracer.Start(ctx, "dockerbridge.getOrCreateContainer")
 	defer span.End()
 
@@ -152,19 +170,19 @@ func (ctr *Container) getOrCreateContainer(ctx context.Context, cli *client.Clie
 
 	if isRunning {
 		span.AddEvent(fmt.Sprintf("Running container found with ID: %s\n", *id))
-		return id, err
+		return id, false, err
 	}
 
 	if id == nil || err != nil {
-		return id, err
+		return id, false, err
 	}
 
 	if err := cli.ContainerStart(ctx, *id, container.StartOptions{}); err != nil {
-		return id, err
+		return id, false, err
 	}
 
 	span.AddEvent(fmt.Sprintf("Container started with Name: %s and ID: %s\n", name, *id))
-	return id, err
+	return id, true, err
 }
 
 func getContainerByName(ctx context.Context, cli *client.Client, name string, tracer trace.Tracer) (*string, bool, error) {
@@ -311,7 +329,7 @@ func tryPullImage(ctx context.Context, imageName string, cli *client.Client, fil
 		return nil, fmt.Errorf(`Failed to pull image "%s". Error: %w`, imageName, err)
 	}
 
-	io.Copy(os.Stdout, reader) // print output of pull
+	io.Copy(os.Stderr, reader) // print output of pull, keeping stdout for the reply
 	reader.Close()
 
 	return cli.ImageList(ctx, image.ListOptions{
@@ -319,11 +337,13 @@ func tryPullImage(ctx context.Context, imageName string, cli *client.Client, fil
 	})
 }
 
+// Entries are either the name of a variable passed through from figaro's environment, or a NAME=value pair.
 func getEnvironmentVariables(variableNames []string) ([]string, error) {
 	retVal := make([]string, len(variableNames))
 	for i, name := range variableNames {
-		if env := os.Getenv(name); env != "" {
-			fmt.Println(fmt.Sprintf("%s=%s", name, env))
+		if strings.Contains(name, "=") {
+			retVal[i] = name
+		} else if env := os.Getenv(name); env != "" {
 			retVal[i] = fmt.Sprintf("%s=%s", name, env)
 		}
 	}
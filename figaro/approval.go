@@ -0,0 +1,202 @@
+package figaro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"figaro/mcp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// How tool calls that need approval are handled.
+type ApprovalMode string
+
+const (
+	// Ask the user about each call that needs approval
+	ApprovalAsk ApprovalMode = "ask"
+	// Run every tool without asking, for unattended runs
+	ApprovalYes ApprovalMode = "yes"
+	// Only run tools that declare themselves read-only, denying the others without asking
+	ApprovalReadOnly ApprovalMode = "read-only"
+)
+
+// What the user answered when asked to approve a tool call.
+type Answer int
+
+const (
+	AllowOnce Answer = iota
+	// Allow this tool from now on, in this project
+	AllowAlways
+	Deny
+)
+
+// Asks the user whether tool may run with input.  Feedback given with a denial is passed on to the model.  Gives up
+// asking once ctx is done.
+type Prompter func(ctx context.Context, tool mcp.Tool, input json.RawMessage) (answer Answer, feedback string, err error)
+
+// A tool call the user, or the approval mode, did not allow.
+type DeniedError struct {
+	Tool     string
+	Feedback string
+}
+
+func (e *DeniedError) Error() string {
+	if e.Feedback == "" {
+		return fmt.Sprintf("the user did not allow %s to run", e.Tool)
+	}
+	return fmt.Sprintf("the user did not allow %s to run: %s", e.Tool, e.Feedback)
+}
+
+// Decides which tool calls may run.  Tools allowed always are remembered per project, in
+// ~/.figaro/approvals.json.
+type Approvals struct {
+	mode    ApprovalMode
+	project string
+	prompt  Prompter
+	// prompts are asked one at a time even when tools are called in parallel
+	lock    sync.Mutex
+	allowed map[string]bool
+}
+
+// NewApprovals loads the tools allowed always in project, which is usually the working directory.
+func NewApprovals(mode ApprovalMode, project string, prompt Prompter) (*Approvals, error) {
+	switch mode {
+	case ApprovalAsk, ApprovalYes, ApprovalReadOnly:
+	default:
+		return nil, fmt.Errorf("unknown approval mode %q", mode)
+	}
+	project, err := filepath.Abs(project)
+	if err != nil {
+		return nil, err
+	}
+	saved, err := loadApprovals()
+	if err != nil {
+		return nil, err
+	}
+	allowed := make(map[string]bool)
+	for _, tool := range saved[project] {
+		allowed[tool] = true
+	}
+	return &Approvals{mode: mode, project: project, prompt: prompt, allowed: allowed}, nil
+}
+
+// NeedsApproval reports whether the tool may change things or reach beyond the machine.  The hints default as the
+// MCP specification says, so a tool that does not describe itself is treated as destructive and open-world.
+func NeedsApproval(tool mcp.Tool) bool {
+	readOnly, destructive, openWorld := false, true, true
+	if annotations := tool.Annotations; annotations != nil {
+		if annotations.ReadOnlyHint != nil {
+			readOnly = *annotations.ReadOnlyHint
+		}
+		if annotations.DestructiveHint != nil {
+			destructive = *annotations.DestructiveHint
+		}
+		if annotations.OpenWorldHint != nil {
+			openWorld = *annotations.OpenWorldHint
+		}
+	}
+	return openWorld || (!readOnly && destructive)
+}
+
+// Check returns nil if the call may go ahead, and a *DeniedError if not, or the cause of ctx if it ended while the
+// user was being asked.
+func (a *Approvals) Check(ctx context.Context, tool mcp.Tool, input json.RawMessage) error {
+	switch a.mode {
+	case ApprovalYes:
+		return nil
+	case ApprovalReadOnly:
+		if tool.Annotations != nil && tool.Annotations.ReadOnlyHint != nil && *tool.Annotations.ReadOnlyHint {
+			return nil
+		}
+		return &DeniedError{Tool: tool.Name, Feedback: "only read-only tools may run in this session"}
+	}
+
+	if !NeedsApproval(tool) {
+		return nil
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if a.allowed[tool.Name] {
+		return nil
+	}
+	if a.prompt == nil {
+		return &DeniedError{Tool: tool.Name, Feedback: "there is no one to approve it"}
+	}
+
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	}
+	answer, feedback, err := a.prompt(ctx, tool, input)
+	if ctx.Err() != nil {
+		return context.Cause(ctx)
+	} else if err != nil {
+		return &DeniedError{Tool: tool.Name, Feedback: err.Error()}
+	}
+	switch answer {
+	case AllowOnce:
+		return nil
+	case AllowAlways:
+		a.allowed[tool.Name] = true
+		return a.save()
+	default:
+		return &DeniedError{Tool: tool.Name, Feedback: feedback}
+	}
+}
+
+func (a *Approvals) save() error {
+	saved, err := loadApprovals()
+	if err != nil {
+		return err
+	}
+	tools := make([]string, 0, len(a.allowed))
+	for tool := range a.allowed {
+		tools = append(tools, tool)
+	}
+	sort.Strings(tools)
+	saved[a.project] = tools
+
+	path, err := approvalsPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0600)
+}
+
+// The tools allowed always, by project directory.
+func loadApprovals() (map[string][]string, error) {
+	path, err := approvalsPath()
+	if err != nil {
+		return nil, err
+	}
+	saved := make(map[string][]string)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return saved, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return saved, nil
+}
+
+func approvalsPath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".figaro", "approvals.json"), nil
+}
@@ -0,0 +1,199 @@
+package figaro
+
+import (
+	"bytes"
+	"encoding/base64"
+	"figaro/anthropicbridge"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Size limits applied before anything is sent to the model.  Images and PDFs are capped by the API itself.
+const (
+	MaxTextAttachmentSize     = 1 << 20
+	MaxImageAttachmentSize    = 5 << 20
+	MaxDocumentAttachmentSize = 32 << 20
+)
+
+type AttachmentKind int
+
+const (
+	TextAttachment AttachmentKind = iota
+	ImageAttachment
+	DocumentAttachment
+)
+
+// A file or piped input sent to the model alongside the prompt.
+type Attachment struct {
+	// Path of the file, or empty for standard input.
+	Path      string
+	Kind      AttachmentKind
+	MediaType string
+	Data      []byte
+}
+
+// ReadAttachment reads the file at path and detects whether it goes to the model as text, an image or a document.
+func ReadAttachment(path string) (*Attachment, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", path)
+	}
+	if info.Size() > MaxDocumentAttachmentSize {
+		return nil, fmt.Errorf("%s is %d bytes, more than the %d allowed", path, info.Size(), MaxDocumentAttachmentSize)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return newAttachment(path, data)
+}
+
+// How long stdin is given to start sending something when it was not asked for explicitly.
+const stdinWait = time.Second
+
+// ReadStdinAttachment reads everything piped to standard input.  Returns nil if stdin is a terminal or empty.  Unless
+// wait is set, a pipe is only read if something comes through it, or it is closed, within a second: stdin may be
+// left open without anything ever coming, as under ssh or docker run -i, and reading it would then never end.
+func ReadStdinAttachment(wait bool) (*Attachment, error) {
+	info, err := os.Stdin.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Mode()&os.ModeCharDevice != 0 {
+		return nil, nil
+	}
+
+	var reader io.Reader = os.Stdin
+	if !wait && !info.Mode().IsRegular() {
+		first, ok, err := readFirst(os.Stdin, stdinWait)
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		if !ok {
+			return nil, nil
+		}
+		reader = io.MultiReader(bytes.NewReader(first), os.Stdin)
+	}
+	data, err := io.ReadAll(io.LimitReader(reader, MaxDocumentAttachmentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > MaxDocumentAttachmentSize {
+		return nil, fmt.Errorf("stdin holds more than the %d bytes allowed", MaxDocumentAttachmentSize)
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	return newAttachment("", data)
+}
+
+// Reads what first comes out of file, giving up after timeout.  The read is then left blocked, taking whatever comes
+// later, which is only fine as the file is not read again.
+func readFirst(file *os.File, timeout time.Duration) ([]byte, bool, error) {
+	type chunk struct {
+		data []byte
+		err  error
+	}
+	read := make(chan chunk, 1)
+	go func() {
+		buffer := make([]byte, 32*1024)
+		n, err := file.Read(buffer)
+		read <- chunk{buffer[:n], err}
+	}()
+
+	select {
+	case first := <-read:
+		return first.data, true, first.err
+	case <-time.After(timeout):
+		return nil, false, nil
+	}
+}
+
+func newAttachment(path string, data []byte) (*Attachment, error) {
+	attachment := &Attachment{
+		Path:      path,
+		MediaType: detectMediaType(path, data),
+		Data:      data,
+	}
+
+	var limit int
+	switch {
+	case anthropicbridge.IsImageMediaType(attachment.MediaType):
+		attachment.Kind = ImageAttachment
+		limit = MaxImageAttachmentSize
+	case attachment.MediaType == "application/pdf":
+		attachment.Kind = DocumentAttachment
+		limit = MaxDocumentAttachmentSize
+	case utf8.Valid(data):
+		attachment.Kind = TextAttachment
+		attachment.MediaType = "text/plain"
+		limit = MaxTextAttachmentSize
+	default:
+		return nil, fmt.Errorf("%s is neither UTF-8 text, an image nor a PDF", attachment.Name())
+	}
+
+	if len(data) > limit {
+		return nil, fmt.Errorf("%s is %d bytes, more than the %d allowed for %s", attachment.Name(), len(data), limit, attachment.MediaType)
+	}
+	return attachment, nil
+}
+
+// Sniffs the content first, as it is more reliable than the extension, then falls back on the extension for
+// formats that are not sniffed.
+func detectMediaType(path string, data []byte) string {
+	mediaType, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if mediaType != "application/octet-stream" && mediaType != "text/plain" {
+		return mediaType
+	}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".pdf":
+		return "application/pdf"
+	case ".webp":
+		return "image/webp"
+	}
+	return mediaType
+}
+
+// Name is how the attachment is referred to in messages and errors.
+func (a *Attachment) Name() string {
+	if a.Path == "" {
+		return "stdin"
+	}
+	return a.Path
+}
+
+// ContentBlock converts the attachment into the block sent to the model.
+func (a *Attachment) ContentBlock() anthropic.ContentBlockParamUnion {
+	switch a.Kind {
+	case ImageAttachment:
+		return anthropic.NewImageBlockBase64(a.MediaType, base64.StdEncoding.EncodeToString(a.Data))
+	case DocumentAttachment:
+		return anthropic.ContentBlockParamUnion{
+			OfRequestDocumentBlock: &anthropic.DocumentBlockParam{
+				Source: anthropic.DocumentBlockParamSourceUnion{
+					OfBase64PDFSource: &anthropic.Base64PDFSourceParam{
+						Data: base64.StdEncoding.EncodeToString(a.Data),
+					},
+				},
+				Title: anthropic.String(filepath.Base(a.Name())),
+			},
+		}
+	default:
+		if a.Path == "" {
+			return anthropic.NewTextBlock(fmt.Sprintf("<stdin>\n%s\n</stdin>", a.Data))
+		}
+		return anthropic.NewTextBlock(fmt.Sprintf("<file name=%q>\n%s\n</file>", a.Path, a.Data))
+	}
+}
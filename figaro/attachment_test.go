@@ -0,0 +1,58 @@
+package figaro
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// Puts a pipe in place of stdin, returning its writing end.
+func pipeStdin(t *testing.T) *os.File {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdin := os.Stdin
+	os.Stdin = r
+	t.Cleanup(func() {
+		os.Stdin = stdin
+		w.Close()
+		r.Close()
+	})
+	return w
+}
+
+func TestReadStdinAttachmentFromPipe(t *testing.T) {
+	w := pipeStdin(t)
+	go func() {
+		w.WriteString("piped text")
+		w.Close()
+	}()
+
+	attachment, err := ReadStdinAttachment(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attachment == nil || string(attachment.Data) != "piped text" {
+		t.Errorf("attachment = %+v, want the piped text", attachment)
+	}
+}
+
+func TestReadStdinAttachmentLeavesIdlePipe(t *testing.T) {
+	pipeStdin(t)
+
+	read := make(chan *Attachment, 1)
+	go func() {
+		attachment, _ := ReadStdinAttachment(false)
+		read <- attachment
+	}()
+	select {
+	case attachment := <-read:
+		if attachment != nil {
+			t.Errorf("attachment = %+v, want none from a pipe nothing was written to", attachment)
+		}
+	case <-time.After(stdinWait + 5*time.Second):
+		t.Fatal("reading a pipe left open with nothing on it did not give up")
+	}
+}
@@ -0,0 +1,126 @@
+package figaro
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// A batch of prompts submitted to the Message Batches API, kept under ~/.figaro/batches so that a later run can
+// check on it and fetch its results.
+type Batch struct {
+	ID    string          `json:"id"`
+	Model anthropic.Model `json:"model"`
+	// The file the prompts were read from
+	Input       string    `json:"input"`
+	SubmittedAt time.Time `json:"submitted_at"`
+	// The custom_id of every request, in the order of the input, which the results are put back in
+	CustomIDs []string `json:"custom_ids"`
+	// What the API said last: in_progress, canceling or ended, and how many requests had come to what
+	Status string                              `json:"status"`
+	Counts anthropic.MessageBatchRequestCounts `json:"request_counts"`
+	// Where the results were last written, if they were
+	ResultsPath string `json:"results_path,omitempty"`
+}
+
+var ErrBatchNotFound = errors.New("batch not found")
+
+var batchIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+// Save writes the batch to its file, creating the batches directory if needed.
+func (b *Batch) Save() error {
+	dir, err := batchDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	byteContents, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, b.ID+".json"), byteContents, 0600)
+}
+
+// LoadBatch reads the batch with the provided id.  Returns ErrBatchNotFound if it was not submitted from here.
+func LoadBatch(id string) (*Batch, error) {
+	if !batchIDPattern.MatchString(id) {
+		return nil, fmt.Errorf("invalid batch id %q", id)
+	}
+	dir, err := batchDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, id+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrBatchNotFound, id)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var batch Batch
+	if err := json.Unmarshal(data, &batch); err != nil {
+		return nil, fmt.Errorf("batch %s: %w", id, err)
+	}
+	return &batch, nil
+}
+
+// ListBatches returns every batch submitted from here, most recent first.
+func ListBatches() ([]*Batch, error) {
+	dir, err := batchDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Batch{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	batches := make([]*Batch, 0, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		batch, err := LoadBatch(id)
+		if err != nil {
+			return nil, err
+		}
+		batches = append(batches, batch)
+	}
+	sort.Slice(batches, func(i, j int) bool {
+		return batches[i].SubmittedAt.After(batches[j].SubmittedAt)
+	})
+	return batches, nil
+}
+
+// LatestBatch returns the batch submitted last.  Returns ErrBatchNotFound if there is none.
+func LatestBatch() (*Batch, error) {
+	batches, err := ListBatches()
+	if err != nil {
+		return nil, err
+	}
+	if len(batches) == 0 {
+		return nil, ErrBatchNotFound
+	}
+	return batches[0], nil
+}
+
+func batchDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".figaro", "batches"), nil
+}
@@ -0,0 +1,231 @@
+package figaro
+
+import (
+	"context"
+	"encoding/json"
+	"figaro/llm"
+	"figaro/mcp"
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Rough token counts for content that is not text.  The API does not say what an image costs until it has been
+// sent, and PDFs depend on their pages, so these are only meant to be in the right range.
+const (
+	imageTokens        = 1600
+	pdfBytesPerToken   = 16
+	charactersPerToken = 4
+)
+
+const trimmedToolResult = "[figaro] This tool result was removed to save context."
+
+// Estimates the prompt size of params in tokens.  The prompt of the last turn is known exactly from its usage,
+// so only what was added since is guessed at.
+func (figaro *Figaro) estimatePrompt(params *anthropic.MessageNewParams) int64 {
+	if figaro.promptTokens > 0 && figaro.promptMessages <= len(params.Messages) {
+		return figaro.promptTokens + estimateMessages(params.Messages[figaro.promptMessages:])
+	}
+	estimate := estimateMessages(params.Messages)
+	for _, block := range params.System {
+		estimate += int64(len(block.Text)) / charactersPerToken
+	}
+	if tools, err := json.Marshal(params.Tools); err == nil {
+		estimate += int64(len(tools)) / charactersPerToken
+	}
+	return estimate
+}
+
+// Remembers how large the prompt of a turn turned out to be, for estimatePrompt.
+func (figaro *Figaro) calibratePrompt(messages int, usage anthropic.Usage) {
+	figaro.promptMessages = messages
+	figaro.promptTokens = usage.InputTokens + usage.CacheCreationInputTokens + usage.CacheReadInputTokens
+}
+
+// Forgets the size of the last prompt, once the conversation has changed other than by being added to.
+func (figaro *Figaro) resetPrompt() {
+	figaro.promptMessages = 0
+	figaro.promptTokens = 0
+}
+
+func estimateMessages(messages []anthropic.MessageParam) int64 {
+	estimate := int64(0)
+	for _, message := range messages {
+		for _, block := range message.Content {
+			estimate += estimateBlock(block)
+		}
+	}
+	return estimate
+}
+
+func estimateBlock(block anthropic.ContentBlockParamUnion) int64 {
+	switch {
+	case block.OfRequestTextBlock != nil:
+		return int64(len(block.OfRequestTextBlock.Text)) / charactersPerToken
+	case block.OfRequestImageBlock != nil:
+		return imageTokens
+	case block.OfRequestDocumentBlock != nil && block.OfRequestDocumentBlock.Source.OfBase64PDFSource != nil:
+		return int64(len(block.OfRequestDocumentBlock.Source.OfBase64PDFSource.Data)) / pdfBytesPerToken
+	case block.OfRequestToolResultBlock != nil:
+		estimate := int64(0)
+		for _, content := range block.OfRequestToolResultBlock.Content {
+			if content.OfRequestImageBlock != nil {
+				estimate += imageTokens
+			} else if text := content.GetText(); text != nil {
+				estimate += int64(len(*text)) / charactersPerToken
+			}
+		}
+		return estimate
+	default:
+		raw, err := json.Marshal(block)
+		if err != nil {
+			return 0
+		}
+		return int64(len(raw)) / charactersPerToken
+	}
+}
+
+// Keeps the conversation within the model's context window.  Once the next prompt would fill more than the
+// configured share of it, the results of older tool calls are dropped, and if that is not enough, the older turns
+// are replaced by a summary the model writes of them.  The latest messages are always kept as they are.
+func (figaro *Figaro) compact(
+	ctx context.Context,
+	bridge llm.Provider,
+	session *Session,
+	tools []mcp.Tool,
+	b *budget,
+) error {
+	settings := figaro.compaction
+	if settings.Disabled {
+		return nil
+	}
+	threshold := int64(float64(settings.GetContextWindow(string(figaro.model))) * settings.GetCompactAt())
+	estimate := func() int64 {
+		return figaro.estimatePrompt(GetMessageNewParams(session.Messages, tools, figaro.model, figaro.system, figaro.generation))
+	}
+	before := estimate()
+	if before < threshold {
+		return nil
+	}
+
+	span := trace.SpanFromContext(ctx)
+	keep := len(session.Messages) - settings.GetKeepMessages()
+	if keep < 1 {
+		span.AddEvent("Conversation too large but too short to compact")
+		return nil
+	}
+
+	trimmed := trimToolResults(session.Messages[:keep])
+	if trimmed > 0 {
+		figaro.resetPrompt()
+		if after := estimate(); after < threshold {
+			figaro.reportCompaction(ctx, fmt.Sprintf("dropped %d old tool results, about %d tokens down to %d",
+				trimmed, before, after))
+			return nil
+		}
+	}
+
+	cut := compactionCut(session.Messages, keep)
+	if cut < 1 {
+		span.AddEvent("No turn to compact the conversation at")
+		return nil
+	}
+	summary, err := figaro.summarizeConversation(ctx, bridge, session.Messages[:cut], tools, b)
+	if err != nil {
+		return fmt.Errorf("failed to compact the conversation: %w", err)
+	}
+	compacted := make([]anthropic.MessageParam, 0, len(session.Messages)-cut+1)
+	compacted = append(compacted, anthropic.NewUserMessage(anthropic.NewTextBlock(
+		"[figaro] The start of this conversation was compacted to save context. This is a summary of it:\n\n"+summary)))
+	session.Messages = append(compacted, session.Messages[cut:]...)
+	figaro.resetPrompt()
+	figaro.reportCompaction(ctx, fmt.Sprintf("summarized %d earlier messages, about %d tokens down to %d",
+		cut, before, estimate()))
+	return nil
+}
+
+func (figaro *Figaro) reportCompaction(ctx context.Context, text string) {
+	trace.SpanFromContext(ctx).AddEvent("Conversation compacted", trace.WithAttributes(attribute.String("result", text)))
+	figaro.output.Emit(Event{Type: EventCompact, Text: text})
+}
+
+// Replaces the content of the tool results among messages with a note, keeping the blocks themselves so that
+// each still answers its tool_use.  Returns how many were replaced.
+func trimToolResults(messages []anthropic.MessageParam) int {
+	trimmed := 0
+	for i := range messages {
+		for j := range messages[i].Content {
+			result := messages[i].Content[j].OfRequestToolResultBlock
+			if result == nil || isTrimmed(result) {
+				continue
+			}
+			// copied, so that a conversation sharing the block keeps its content
+			copied := *result
+			copied.Content = []anthropic.ToolResultBlockParamContentUnion{
+				{OfRequestTextBlock: &anthropic.TextBlockParam{Text: trimmedToolResult}},
+			}
+			messages[i].Content[j].OfRequestToolResultBlock = &copied
+			trimmed++
+		}
+	}
+	return trimmed
+}
+
+func isTrimmed(result *anthropic.ToolResultBlockParam) bool {
+	if len(result.Content) != 1 {
+		return false
+	}
+	text := result.Content[0].GetText()
+	return text != nil && *text == trimmedToolResult
+}
+
+// Finds where to split the conversation for a summary, at or after keep: the first assistant message from there.
+// Everything before it goes into the summary, a user turn, which the assistant message can follow.  A tool_use is
+// always in the message right before its tool_result, so no pair is split.
+func compactionCut(messages []anthropic.MessageParam, keep int) int {
+	for i := keep; i < len(messages); i++ {
+		if messages[i].Role == anthropic.MessageParamRoleAssistant {
+			return i
+		}
+	}
+	return 0
+}
+
+// Asks the model for a summary of messages, which end with a user turn, without showing it as it comes.
+func (figaro *Figaro) summarizeConversation(
+	ctx context.Context,
+	bridge llm.Provider,
+	messages []anthropic.MessageParam,
+	tools []mcp.Tool,
+	b *budget,
+) (string, error) {
+	conversation := make([]anthropic.MessageParam, len(messages))
+	copy(conversation, messages)
+	last := &conversation[len(conversation)-1]
+	last.Content = append(last.Content[:len(last.Content):len(last.Content)], anthropic.NewTextBlock(
+		"[figaro] The conversation is getting too long. Summarize it so far, so that it can be continued from the "+
+			"summary alone: what was asked, what was found and done, with the facts, names and paths that matter, "+
+			"and what is left to do. Reply with the summary only."))
+
+	params := GetMessageNewParams(conversation, tools, figaro.model, figaro.system, figaro.generation)
+	withoutTools(params)
+	message, err := bridge.NewMessage(ctx, *params)
+	if err != nil {
+		return "", &ModelError{Err: err}
+	}
+	figaro.countUsage(b, message.Usage)
+
+	parts := make([]string, 0, len(message.Content))
+	for _, block := range message.Content {
+		if block.Type == "text" {
+			parts = append(parts, block.Text)
+		}
+	}
+	if len(parts) == 0 {
+		return "", fmt.Errorf("the model replied without a summary (stop reason %s)", message.StopReason)
+	}
+	return strings.Join(parts, "\n"), nil
+}
@@ -3,16 +3,22 @@ package figaro
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"figaro/anthropicbridge"
+	"figaro/config"
 	"figaro/dockerbridge"
 	"figaro/jsonrpc"
+	"figaro/llm"
 	"figaro/logging"
 	"figaro/mcp"
+	"figaro/openaibridge"
 	"fmt"
 	"os"
-	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"time"
+	"unicode"
 
 	"github.com/anthropics/anthropic-sdk-go"
 	"go.opentelemetry.io/otel/attribute"
@@ -22,14 +28,54 @@ import (
 const FigaroChi = "figaro"
 
 type Figaro struct {
-	clients         []mcpClientWrapper
-	toolsCache      []mcp.Tool // Might get stale when we implement dynamic tool introduction
-	tracerProvider  trace.TracerProvider
-	anthropicbridge *anthropicbridge.AnthropicBridge
+	clients        []mcpClientWrapper
+	tools          []exposedTool // Might get stale when we implement dynamic tool introduction
+	toolIndex      map[string]int
+	toolCollisions []string
+	tracerProvider trace.TracerProvider
+	providers      map[string]config.Provider
+	// the providers set up so far, by name, with Anthropic's under ""
+	bridges    map[string]llm.Provider
+	session    *Session
+	model      anthropic.Model
+	system     []anthropic.TextBlockParam
+	generation config.Generation
+	limits     config.Limits
+	prices     map[string]config.Price
+	compaction config.Compaction
+	// the size of the last prompt sent, as the api counted it, and how many messages it held
+	promptTokens   int64
+	promptMessages int
+	output         Output
+	// bounds the tool calls in flight over all servers, each server having its own bound as well
+	toolSlots chan struct{}
+	// nil runs every tool without asking
+	approvals *Approvals
+}
+
+type Opts struct {
+	limits config.Limits
+}
+
+type OptsFunc func(o *Opts)
+
+// Bounds how long requests and tool calls may take.
+func WithLimits(limits config.Limits) OptsFunc {
+	return func(o *Opts) {
+		o.limits = limits
+	}
 }
 
 type ServerRegistry struct {
-	DockerServers []dockerbridge.ContainerDefinition `json:"docker_servers"`
+	DockerServers []DockerServer `json:"docker_servers"`
+}
+
+type DockerServer struct {
+	dockerbridge.ContainerDefinition
+	Name string `json:"name"`
+	// Put in front of the server's tool names, as prefix__tool, so that tools of different servers cannot be
+	// confused.  Empty leaves the names as the server gives them.
+	ToolPrefix string `json:"tool_prefix"`
 }
 
 // Initializes an instance of a Figaro application configured with the provided server list, and returns it.
@@ -37,7 +83,12 @@ type ServerRegistry struct {
 // Otherwise, it will always have a non-nil value, even if empty list.
 // If server does not return any tools by responding with nil tools in result rather than empty list, that's fine,
 // it's interpreted to mean empty list for interest of compatibility.
-func SummonFigaro(ctx context.Context, tp trace.TracerProvider, servers ServerRegistry) (*Figaro, context.CancelCauseFunc, error) {
+func SummonFigaro(ctx context.Context, tp trace.TracerProvider, servers ServerRegistry, opts ...OptsFunc) (*Figaro, context.CancelCauseFunc, error) {
+	o := Opts{}
+	for _, optFunc := range opts {
+		optFunc(&o)
+	}
+
 	ctx, cancel := context.WithCancelCause(ctx)
 
 	tracer := tp.Tracer("figaro")
@@ -58,15 +109,15 @@ func SummonFigaro(ctx context.Context, tp trace.TracerProvider, servers ServerRe
 				select {
 				case <-ctx.Done():
 					cancelConn()
-					break
+					return
 				case <-connCtx.Done():
 					cancel(connCtx.Err())
-					break
+					return
 				}
 			}
 		}()
 
-		connection, connectionDone, err := dockerbridge.Setup(connCtx, server, tp)
+		connection, connectionDone, err := dockerbridge.Setup(connCtx, server.ContainerDefinition, tp)
 		if err != nil {
 			cancel(err)
 			cancelConn()
@@ -82,15 +133,16 @@ func SummonFigaro(ctx context.Context, tp trace.TracerProvider, servers ServerRe
 				select {
 				case <-ctx.Done():
 					cancelConn()
-					break
+					return
 				case <-rpcCtx.Done():
 					cancel(rpcCtx.Err())
-					break
+					return
 				}
 			}
 		}()
 
-		client, rpcDone, err := jsonrpc.NewStdioClient[string](rpcCtx, connection, tp)
+		client, rpcDone, err := jsonrpc.NewStdioClient[string](rpcCtx, connection, tp,
+			jsonrpc.WithTimeout(o.limits.GetToolTimeout()), mcp.WithCancellation())
 		if err != nil {
 			cancelConn()
 			cancelRpc()
@@ -98,7 +150,7 @@ func SummonFigaro(ctx context.Context, tp trace.TracerProvider, servers ServerRe
 			return nil, nil, err
 		}
 
-		mcpClient, err := mcp.Initialize(ctx, server, client, tp)
+		mcpClient, err := mcp.Initialize(ctx, server.ContainerDefinition, client, tp)
 		if err != nil {
 			cancelConn()
 			cancelRpc()
@@ -108,7 +160,10 @@ func SummonFigaro(ctx context.Context, tp trace.TracerProvider, servers ServerRe
 
 		// add failure logging at this level
 		mcpClients[i] = mcpClientWrapper{
-			mcpClient: mcpClient,
+			name:       server.Name,
+			toolPrefix: server.ToolPrefix,
+			mcpClient:  mcpClient,
+			toolSlots:  make(chan struct{}, o.limits.GetMaxParallelToolsPerServer()),
 			connection: &lifeCycleWrapper{
 				done:   connectionDone,
 				cancel: cancelConn,
@@ -121,10 +176,42 @@ func SummonFigaro(ctx context.Context, tp trace.TracerProvider, servers ServerRe
 		}
 	}
 
-	return &Figaro{
+	figaro := &Figaro{
 		clients:        mcpClients,
 		tracerProvider: tp,
-	}, cancel, nil
+		model:          anthropicbridge.DefaultModel,
+		limits:         o.limits,
+		output:         &consoleOutput{out: os.Stdout, diag: os.Stderr},
+		toolSlots:      make(chan struct{}, o.limits.GetMaxParallelTools()),
+	}
+	figaro.indexTools()
+	for _, collision := range figaro.toolCollisions {
+		span.AddEvent("Tool name collision", trace.WithAttributes(attribute.String("collision", collision)))
+	}
+	return figaro, figaro.teardownOnCancel(cancel, teardownTimeout), nil
+}
+
+// How long cancelling a figaro waits for its servers to be torn down.
+const teardownTimeout = 15 * time.Second
+
+// Wraps cancel to wait, up to timeout, for the connections to the servers to be closed and the containers started
+// for them stopped, so that the process does not exit halfway through.
+func (figaro *Figaro) teardownOnCancel(cancel context.CancelCauseFunc, timeout time.Duration) context.CancelCauseFunc {
+	return func(cause error) {
+		cancel(cause)
+		deadline := time.After(timeout)
+		for _, client := range figaro.clients {
+			select {
+			case err := <-client.connection.done:
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "warning: failed to stop server %s: %v\n", client.name, err)
+				}
+			case <-deadline:
+				fmt.Fprintf(os.Stderr, "warning: gave up waiting for the servers to stop after %v\n", timeout)
+				return
+			}
+		}
+	}
 }
 
 type serviceWrapper[T any] struct {
@@ -139,224 +226,746 @@ type lifeCycleWrapper struct {
 }
 
 type mcpClientWrapper struct {
+	name       string
+	toolPrefix string
 	mcpClient  *mcp.Client
 	connection *lifeCycleWrapper
 	rpcClient  *serviceWrapper[jsonrpc.Client]
+	toolSlots  chan struct{}
 }
 
-func (figaro *Figaro) GetClientForTool(toolName string) *mcp.Client {
-	for _, clientWrapper := range figaro.clients {
-		client := clientWrapper.mcpClient
-		for _, tool := range client.Tools {
-			if tool.Name == toolName {
-				return client
+// A tool under the name the model knows it by, and the server that has it under its own name.
+type exposedTool struct {
+	tool         mcp.Tool
+	originalName string
+	client       *mcpClientWrapper
+}
+
+// Separates the server's prefix from the tool's own name.
+const ToolNameSeparator = "__"
+
+// The model only accepts tool names made of these.
+var invalidToolNameChars = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+
+const maxToolNameLength = 64
+
+// ExposedToolName is the name the model sees for a tool of a server: prefix__tool, with characters the model does
+// not accept replaced.
+func ExposedToolName(prefix string, tool string) string {
+	name := tool
+	if prefix != "" {
+		name = prefix + ToolNameSeparator + tool
+	}
+	return invalidToolNameChars.ReplaceAllString(name, "_")
+}
+
+// Names every tool as the model will see it.  When two tools end up with the same name, the one from the server
+// listed first keeps it and the collision is recorded, to be reported by ToolCollisions.
+func (figaro *Figaro) indexTools() {
+	figaro.tools = make([]exposedTool, 0)
+	figaro.toolIndex = make(map[string]int)
+	figaro.toolCollisions = make([]string, 0)
+	for i := range figaro.clients {
+		clientWrapper := &figaro.clients[i]
+		for _, tool := range clientWrapper.mcpClient.Tools {
+			name := ExposedToolName(clientWrapper.toolPrefix, tool.Name)
+			if len(name) > maxToolNameLength {
+				figaro.toolCollisions = append(figaro.toolCollisions, fmt.Sprintf(
+					"tool %q of server %q is left out: %q is longer than %d characters, set a shorter tool_prefix",
+					tool.Name, clientWrapper.name, name, maxToolNameLength))
+				continue
+			}
+			if existing, taken := figaro.toolIndex[name]; taken {
+				figaro.toolCollisions = append(figaro.toolCollisions, fmt.Sprintf(
+					"tool %q of server %q is left out: %q is already the name of tool %q of server %q, set tool_prefix to tell them apart",
+					tool.Name, clientWrapper.name, name, figaro.tools[existing].originalName, figaro.tools[existing].client.name))
+				continue
 			}
+			exposed := tool
+			exposed.Name = name
+			figaro.toolIndex[name] = len(figaro.tools)
+			figaro.tools = append(figaro.tools, exposedTool{tool: exposed, originalName: tool.Name, client: clientWrapper})
 		}
 	}
-	return nil
 }
 
-func (figaro *Figaro) GetAllTools() []mcp.Tool {
-	if figaro.toolsCache != nil {
-		return figaro.toolsCache
+// ToolCollisions describes the tools left out because their name was taken, or too long once prefixed.
+func (figaro *Figaro) ToolCollisions() []string {
+	return figaro.toolCollisions
+}
+
+// GetClientForTool returns the client of the server offering the tool the model knows as toolName.
+func (figaro *Figaro) GetClientForTool(toolName string) *mcp.Client {
+	if index, ok := figaro.toolIndex[toolName]; ok {
+		return figaro.tools[index].client.mcpClient
 	}
-	cumToolSize := 0
-	for _, clientWrapper := range figaro.clients {
-		client := clientWrapper.mcpClient
-		cumToolSize += len(client.Tools)
+	return nil
+}
+
+// GetTool looks a tool up by the name the model knows it by.
+func (figaro *Figaro) GetTool(toolName string) (mcp.Tool, bool) {
+	if index, ok := figaro.toolIndex[toolName]; ok {
+		return figaro.tools[index].tool, true
 	}
-	result := make([]mcp.Tool, cumToolSize)
-	for i, clientWrapper := range figaro.clients {
-		client := clientWrapper.mcpClient
-		for j, tool := range client.Tools {
-			result[i+j] = tool
-		}
+	return mcp.Tool{}, false
+}
+
+// GetAllTools returns the tools of every server, named as the model sees them.
+func (figaro *Figaro) GetAllTools() []mcp.Tool {
+	result := make([]mcp.Tool, len(figaro.tools))
+	for i, exposed := range figaro.tools {
+		result[i] = exposed.tool
 	}
-	figaro.toolsCache = result
 	return result
 }
 
-func (figaro *Figaro) Request(args []string, modePtr *string) error {
-	ctx, cancel := context.WithTimeoutCause(context.Background(), time.Duration(time.Minute), fmt.Errorf("Operation timed out"))
+// Sends input to the model as a new user turn on the current conversation, then keeps calling tools on the
+// model's behalf until it stops asking for them.  The conversation survives between calls so that an interactive
+// session can keep building on it.  Attachments are placed ahead of the input in the same turn.
+func (figaro *Figaro) Request(ctx context.Context, input string, attachments ...*Attachment) (err error) {
+	ctx, cancel := context.WithTimeoutCause(ctx, figaro.limits.GetRequestTimeout(), ErrTimeout)
 	defer cancel()
+	// whatever failed on the way out of an interrupted request, it was interrupted
+	defer func() {
+		if err != nil && errors.Is(context.Cause(ctx), ErrInterrupted) {
+			err = ErrInterrupted
+		}
+	}()
 
 	tracer := figaro.tracerProvider.Tracer("figaro")
 	ctx, span := tracer.Start(ctx, "request")
 	defer span.End()
 
 	tools := figaro.GetAllTools()
-	input := strings.Join(args, " ")
 
 	span.AddEvent("Tools retrieved",
 		trace.WithAttributes(attribute.String("serialized_tools", logging.EzMarshal(tools))))
 
-	role := anthropic.MessageParamRole(string(anthropic.MessageParamRoleUser))
+	provider, err := figaro.getProvider()
+	if err != nil {
+		return err
+	}
 
-	conversation := make([]anthropic.MessageParam, 0, 1)
-	anthropicClient, err := anthropicbridge.InitAnthropic(anthropicbridge.WithLogging(figaro.tracerProvider))
+	session, err := figaro.Session()
 	if err != nil {
-		return fmt.Errorf("failed to initialize Anthropic client: %w", err)
+		return err
 	}
+	b := newBudget(figaro.limits)
+	// persist whatever was gathered, even if the request fails part way through the tool loop
+	defer func() {
+		session.Model = figaro.model
+		figaro.finishUsage(ctx, session, b.usage)
+		if err := session.Save(); err != nil {
+			span.AddEvent("Failed to save session", trace.WithAttributes(attribute.String("error", err.Error())))
+		}
+	}()
 
-	for range 1 {
-		conversation = append(conversation, anthropic.MessageParam{
-			Content: []anthropic.ContentBlockParamUnion{{
-				OfRequestTextBlock: &anthropic.TextBlockParam{Text: input},
-			}},
-			Role: role,
-		})
-		messageParams := GetMessageNewParams(conversation, tools)
-		stream, err := anthropicClient.StreamMessage(context.Background(), *messageParams)
+	content := make([]anthropic.ContentBlockParamUnion, 0, len(attachments)+1)
+	for _, attachment := range attachments {
+		content = append(content, attachment.ContentBlock())
+	}
+	if input != "" {
+		content = append(content, anthropic.NewTextBlock(input))
+	}
+	if len(content) == 0 {
+		return errors.New("nothing to send")
+	}
+
+	session.Messages = append(session.Messages, anthropic.MessageParam{
+		Content: content,
+		Role:    anthropic.MessageParamRoleUser,
+	})
+
+	for {
+		if err := figaro.compact(ctx, provider, session, tools, b); err != nil {
+			return err
+		}
+		modelResponse, stopReason, err := figaro.streamTurn(ctx, provider, session.Messages, tools, b)
+		if err != nil && !errors.Is(err, ErrInterrupted) {
+			return err
+		}
+		figaro.addReply(session, modelResponse, stopReason)
 		if err != nil {
 			return err
 		}
 
-		var message *anthropic.Message
-	progressLoop:
-		for {
-			select {
-			case err := <-stream.Error:
-				cancel()
-				return err
-			case next := <-stream.Progress:
-				fmt.Print(next)
-			case message = <-stream.Result:
-				break progressLoop
-			}
+		if stopReason != anthropic.MessageStopReasonToolUse {
+			break
+		}
+
+		toolResults, dispatched, err := callTools(ctx, modelResponse, figaro, b.toolCallsLeft())
+		if len(toolResults) > 0 {
+			session.Messages = append(session.Messages, anthropic.MessageParam{
+				Content: toolResults,
+				Role:    anthropic.MessageParamRoleUser,
+			})
+		}
+		if err != nil {
+			return err
 		}
 
-		modelResponse := make([]anthropic.ContentBlockParamUnion, 0, len(message.Content))
-		for _, message := range message.Content {
-			modelResponse = append(modelResponse, message.ToParam())
+		b.iterations++
+		b.toolCalls += dispatched
+		if reason := b.exhausted(); reason != "" {
+			return figaro.summarize(ctx, provider, session, tools, b, reason)
 		}
-		conversation = append(conversation, anthropic.MessageParam{
-			Content: modelResponse,
+	}
+
+	return nil
+}
+
+// Adds the model's turn to the conversation, unless there is nothing to it, as when it was interrupted before it
+// got to say anything.
+func (figaro *Figaro) addReply(session *Session, content []anthropic.ContentBlockParamUnion, stopReason anthropic.MessageStopReason) {
+	if len(content) == 0 {
+		return
+	}
+	session.Messages = append(session.Messages, anthropic.MessageParam{
+		Content: content,
+		Role:    anthropic.MessageParamRoleAssistant,
+	})
+	figaro.output.Emit(Event{
+		Type:       EventMessage,
+		Message:    &session.Messages[len(session.Messages)-1],
+		StopReason: stopReason,
+	})
+}
+
+// Streams the model's next turn to the console.  A turn cut off by max_tokens is continued, by sending it back as
+// the start of the assistant's reply, as many times as the generation settings allow.  An interrupted turn returns
+// the text streamed so far along with ErrInterrupted.
+func (figaro *Figaro) streamTurn(
+	ctx context.Context,
+	bridge llm.Provider,
+	conversation []anthropic.MessageParam,
+	tools []mcp.Tool,
+	b *budget,
+	adjustments ...func(*anthropic.MessageNewParams),
+) (
+	[]anthropic.ContentBlockParamUnion,
+	anthropic.MessageStopReason,
+	error,
+) {
+	span := trace.SpanFromContext(ctx)
+
+	messageParams := GetMessageNewParams(conversation, tools, figaro.model, figaro.system, figaro.generation)
+	for _, adjust := range adjustments {
+		adjust(messageParams)
+	}
+	message, err := figaro.streamReply(ctx, bridge, *messageParams)
+	if err != nil {
+		return partialReply(nil, err), "", err
+	}
+	figaro.countUsage(b, message.Usage)
+	figaro.calibratePrompt(len(conversation), message.Usage)
+	content := toContentParams(message.Content)
+	stopReason := message.StopReason
+
+	for continuations := 0; stopReason == anthropic.MessageStopReasonMaxTokens; continuations++ {
+		// the api only accepts a partial reply that ends in text, and without trailing whitespace, and none at all
+		// when thinking
+		last := len(content) - 1
+		if continuations >= figaro.generation.MaxContinuations || last < 0 || content[last].OfRequestTextBlock == nil ||
+			figaro.generation.ThinkingBudget > 0 {
+			fmt.Fprintf(os.Stderr, "\n[reply cut off at %d tokens]\n", messageParams.MaxTokens)
+			break
+		}
+		content[last].OfRequestTextBlock.Text = strings.TrimRightFunc(content[last].OfRequestTextBlock.Text, unicode.IsSpace)
+
+		span.AddEvent("Continuing reply cut off by max_tokens", trace.WithAttributes(attribute.Int("continuation", continuations+1)))
+		prefilled := append(conversation[:len(conversation):len(conversation)], anthropic.MessageParam{
+			Content: content,
 			Role:    anthropic.MessageParamRoleAssistant,
 		})
+		messageParams := GetMessageNewParams(prefilled, tools, figaro.model, figaro.system, figaro.generation)
+		for _, adjust := range adjustments {
+			adjust(messageParams)
+		}
+		message, err := figaro.streamReply(ctx, bridge, *messageParams)
+		if err != nil {
+			return partialReply(content, err), "", err
+		}
+		figaro.countUsage(b, message.Usage)
 
-		for message.StopReason == "tool_use" {
-			toolResponses, err := callTools(ctx, message, figaro)
-			if err != nil {
-				return err
-			}
+		continued := toContentParams(message.Content)
+		if len(continued) > 0 && continued[0].OfRequestTextBlock != nil {
+			content[last].OfRequestTextBlock.Text += continued[0].OfRequestTextBlock.Text
+			continued = continued[1:]
+		}
+		content = append(content, continued...)
+		stopReason = message.StopReason
+	}
 
-			toolResults := make([]anthropic.ContentBlockParamUnion, 0)
-			for id, toolResponse := range toolResponses {
-				toolResults = append(toolResults, anthropic.ContentBlockParamUnion{
-					OfRequestToolResultBlock: &anthropic.ToolResultBlockParam{
-						ToolUseID: id,
-						Content: []anthropic.ToolResultBlockParamContentUnion{{
-							OfRequestTextBlock: &anthropic.TextBlockParam{
-								Text: anyToString(toolResponse.Result),
-							},
-						}},
-					},
-				})
-			}
+	return content, stopReason, nil
+}
 
-			conversation = append(conversation, anthropic.MessageParam{
-				Content: toolResults,
-				Role:    anthropic.MessageParamRoleUser,
-			})
+func toContentParams(blocks []anthropic.ContentBlockUnion) []anthropic.ContentBlockParamUnion {
+	params := make([]anthropic.ContentBlockParamUnion, 0, len(blocks))
+	for _, block := range blocks {
+		params = append(params, block.ToParam())
+	}
+	return params
+}
 
-			messageParams = GetMessageNewParams(conversation, tools)
-			stream, err = anthropicClient.StreamMessage(ctx, *messageParams)
-			if err != nil {
-				cancel()
-				return err
-			}
+// A reply cut short by the user, with the text streamed until then.
+type interruptedError struct {
+	text []string
+}
 
-		progressLoop2:
-			for {
-				select {
-				case err := <-stream.Error:
-					cancel()
-					return err
-				case next, ok := <-stream.Progress:
-					if !ok {
-						// Progress channel closed, move on to result
-						break progressLoop2
-					}
-					fmt.Print(next)
+func (e *interruptedError) Error() string { return ErrInterrupted.Error() }
+func (e *interruptedError) Unwrap() error { return ErrInterrupted }
+
+// Adds the text of an interrupted reply to the content that came before it, whole, if err is an interruption.
+// Anything else of the reply, thinking without its signature or a tool call without all of its input, could not
+// be sent back.
+func partialReply(content []anthropic.ContentBlockParamUnion, err error) []anthropic.ContentBlockParamUnion {
+	var interrupted *interruptedError
+	if !errors.As(err, &interrupted) {
+		return nil
+	}
+	for _, text := range interrupted.text {
+		// the api refuses empty text blocks, and trailing whitespace in the last assistant turn
+		if text = strings.TrimRightFunc(text, unicode.IsSpace); text != "" {
+			content = append(content, anthropic.NewTextBlock(text))
+		}
+	}
+	return content
+}
+
+// Streams a single model reply to the output and returns the accumulated message once the stream is done.
+func (figaro *Figaro) streamReply(ctx context.Context, bridge llm.Provider, params anthropic.MessageNewParams) (*anthropic.Message, error) {
+	events, err := bridge.StreamMessage(ctx, params)
+	if err != nil {
+		return nil, &ModelError{Err: err}
+	}
+
+	// the tool calls being streamed, by block, to tell which one more input is for
+	toolUses := make(map[int]Event)
+	// the text so far, by block, in case the reply is interrupted
+	texts := make(map[int]*strings.Builder)
+	order := make([]int, 0, 1)
+	for event := range events {
+		switch event.Type {
+		case llm.StreamTextDelta:
+			if _, ok := texts[event.Index]; !ok {
+				texts[event.Index] = &strings.Builder{}
+				order = append(order, event.Index)
+			}
+			texts[event.Index].WriteString(event.Text)
+			figaro.output.Emit(Event{Type: EventTextDelta, Text: event.Text})
+		case llm.StreamThinkingDelta:
+			figaro.output.Emit(Event{Type: EventThinkingDelta, Text: event.Text, Signature: event.Signature})
+		case llm.StreamToolUseStart:
+			toolUses[event.Index] = Event{ID: event.ID, Name: event.Name}
+			figaro.output.Emit(Event{Type: EventToolUseStart, ID: event.ID, Name: event.Name})
+		case llm.StreamInputJSONDelta:
+			toolUse := toolUses[event.Index]
+			figaro.output.Emit(Event{Type: EventToolInputDelta, ID: toolUse.ID, Name: toolUse.Name, Text: event.Text})
+		case llm.StreamError:
+			if errors.Is(context.Cause(ctx), ErrInterrupted) {
+				interrupted := &interruptedError{text: make([]string, 0, len(order))}
+				for _, index := range order {
+					interrupted.text = append(interrupted.text, texts[index].String())
 				}
+				return nil, interrupted
+			}
+			// a stream cut short by the context fails with the reason the context gives
+			if ctx.Err() != nil {
+				return nil, context.Cause(ctx)
 			}
+			return nil, &ModelError{Err: event.Err}
+		case llm.StreamMessageStop:
+			return event.Message, nil
+		}
+	}
+	return nil, &ModelError{Err: errors.New("the stream ended without a reply")}
+}
+
+// Returns the provider serving the current model, setting it up on first use: the one named by the model's
+// "provider:" prefix, or Anthropic's.
+func (figaro *Figaro) getProvider() (llm.Provider, error) {
+	name, _, ok := config.SplitModel(string(figaro.model), figaro.providers)
+	if !ok {
+		name = ""
+	}
+	if bridge, ok := figaro.bridges[name]; ok {
+		return bridge, nil
+	}
 
-			message = <-stream.Result
+	var bridge llm.Provider
+	if name == "" {
+		anthropicBridge, err := anthropicbridge.InitAnthropic(
+			anthropicbridge.WithLogging(figaro.tracerProvider),
+			anthropicbridge.WithRetries(figaro.limits.GetMaxRetries(), figaro.limits.GetMaxRetryDelay()),
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize Anthropic client: %w", err)
+		}
+		bridge = &anthropicBridge
+	} else {
+		openAIBridge, err := openaibridge.InitOpenAI(name, figaro.providers[name], openaibridge.WithLogging(figaro.tracerProvider))
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize provider %q: %w", name, err)
 		}
+		bridge = &openAIBridge
+	}
+	if figaro.bridges == nil {
+		figaro.bridges = make(map[string]llm.Provider)
+	}
+	figaro.bridges[name] = bridge
+	return bridge, nil
+}
+
+// Model returns the model used for subsequent requests.
+func (figaro *Figaro) Model() anthropic.Model {
+	return figaro.model
+}
+
+// SetModel changes the model used for subsequent requests.  The conversation is kept.
+func (figaro *Figaro) SetModel(model anthropic.Model) {
+	figaro.model = model
+}
+
+// SetProviders makes models named "provider:model" available from the configured providers.
+func (figaro *Figaro) SetProviders(providers map[string]config.Provider) {
+	figaro.providers = providers
+	figaro.bridges = nil
+}
+
+// SetApprovals decides which tool calls need the user's approval.  Without, every tool runs.
+func (figaro *Figaro) SetApprovals(approvals *Approvals) {
+	figaro.approvals = approvals
+}
+
+// SetOutput changes how replies and tool activity are rendered.
+func (figaro *Figaro) SetOutput(output Output) {
+	figaro.output = output
+}
+
+// SetGeneration replaces the sampling settings sent with every request.
+func (figaro *Figaro) SetGeneration(generation config.Generation) {
+	figaro.generation = generation
+}
+
+// SetPrices sets what each model costs, for the usage reported.  Usage of models without a price is counted but
+// not priced.
+func (figaro *Figaro) SetPrices(prices map[string]config.Price) {
+	figaro.prices = prices
+}
+
+// SetCompaction decides when older turns are compacted to keep the conversation within the context window.
+func (figaro *Figaro) SetCompaction(compaction config.Compaction) {
+	figaro.compaction = compaction
+}
 
-		writeHostFile(conversation, ".conversation.json")
+// SetSystemPrompt replaces the system prompt sent with every request.  Each part is sent as its own block.
+func (figaro *Figaro) SetSystemPrompt(parts ...string) {
+	figaro.system = make([]anthropic.TextBlockParam, 0, len(parts))
+	for _, part := range parts {
+		if part != "" {
+			figaro.system = append(figaro.system, anthropic.TextBlockParam{Text: part})
+		}
 	}
+}
 
-	return nil
+// UseSession continues the provided session: its turns become the conversation and its model is restored.
+func (figaro *Figaro) UseSession(session *Session) {
+	figaro.session = session
+	figaro.resetPrompt()
+	if session.Model != "" {
+		figaro.model = session.Model
+	}
 }
 
-func writeHostFile(contents any, path ...string) error {
-	homeDir, err := os.UserHomeDir()
+// Session returns the session requests are recorded in, starting a new one if none is in use yet.
+func (figaro *Figaro) Session() (*Session, error) {
+	if figaro.session != nil {
+		return figaro.session, nil
+	}
+	session, err := NewSession("")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	figaro.session = session
+	return session, nil
+}
 
-	relative := filepath.Join(path...)
-	filePath := filepath.Join(homeDir, relative)
-	byteContents, err := json.Marshal(contents)
-	err = os.WriteFile(filePath, byteContents, os.FileMode(os.O_TRUNC))
+// ClearConversation forgets every turn of the current conversation.
+func (figaro *Figaro) ClearConversation() {
+	if figaro.session != nil {
+		figaro.session.Messages = nil
+	}
+	figaro.resetPrompt()
+}
+
+// Conversation returns the turns exchanged so far.
+func (figaro *Figaro) Conversation() []anthropic.MessageParam {
+	if figaro.session == nil {
+		return nil
+	}
+	return figaro.session.Messages
+}
+
+// SaveConversation writes the current conversation as json to the provided path.
+func (figaro *Figaro) SaveConversation(path string) error {
+	byteContents, err := json.MarshalIndent(figaro.Conversation(), "", "  ")
 	if err != nil {
 		return err
 	}
-
-	return nil
+	return os.WriteFile(path, byteContents, 0644)
 }
 
-func GetMessageNewParams(conversation []anthropic.MessageParam, tools []mcp.Tool) *anthropic.MessageNewParams {
+// GetMessageNewParams builds the request for the next turn of conversation, with prompt caching breakpoints set.
+func GetMessageNewParams(
+	conversation []anthropic.MessageParam,
+	tools []mcp.Tool,
+	model anthropic.Model,
+	system []anthropic.TextBlockParam,
+	generation config.Generation,
+) *anthropic.MessageNewParams {
 	anthropicTools := anthropicbridge.GetAnthropicTools(tools)
 	messageParams := &anthropic.MessageNewParams{
-		MaxTokens: 1024,
-		Messages:  conversation,
-		Model:     anthropic.ModelClaude3_7SonnetLatest,
-		Tools:     anthropicTools,
+		MaxTokens:     generation.MaxTokens,
+		Messages:      conversation,
+		Model:         model,
+		System:        system,
+		Tools:         anthropicTools,
+		StopSequences: generation.StopSequences,
+	}
+	if messageParams.MaxTokens == 0 {
+		messageParams.MaxTokens = config.DefaultMaxTokens
+	}
+	if generation.Temperature != nil {
+		messageParams.Temperature = anthropic.Float(*generation.Temperature)
+	}
+	if generation.TopP != nil {
+		messageParams.TopP = anthropic.Float(*generation.TopP)
 	}
+	if generation.TopK != nil {
+		messageParams.TopK = anthropic.Int(*generation.TopK)
+	}
+	if generation.ThinkingBudget > 0 {
+		// max_tokens covers the thinking as well as the reply
+		messageParams.Thinking = anthropic.ThinkingConfigParamOfThinkingConfigEnabled(generation.ThinkingBudget)
+		messageParams.MaxTokens += generation.ThinkingBudget
+	}
+	anthropicbridge.SetCacheBreakpoints(messageParams)
 	return messageParams
 }
 
-func callTools(ctx context.Context, message *anthropic.Message, figaro *Figaro) (map[string]jsonrpc.Message[any], error) {
-	tools := make(map[string]jsonrpc.Message[any], len(message.Content))
-	for _, block := range message.Content {
-		switch variant := block.AsAny().(type) {
-		case anthropic.ToolUseBlock:
-			client := figaro.GetClientForTool(variant.Name)
-			if client == nil {
-				return nil, fmt.Errorf("Could not find mcp client for %v", variant.Name)
-			}
-			var args map[string]any
-			err := json.Unmarshal(variant.Input, &args)
-			if err != nil {
-				return nil, err
+// A tool_use block of the model's and what came of it.
+type toolCall struct {
+	block    *anthropic.ToolUseBlockParam
+	response *jsonrpc.Message[any]
+	err      error
+}
+
+// Calls every tool the model asked for at once, within the concurrency limits, and returns their tool_result
+// blocks in the order of the tool_use blocks.  A failing tool does not end the request: the failure is sent back
+// to the model as an is_error result so that it can try something else.  Only the request running out of time
+// does, or being interrupted, in which case the results are returned along with the cause.  At most maxCalls calls
+// are run, denied ones not counting; the others are answered with an error saying the limit was reached.  Returns
+// how many calls were run.
+func callTools(
+	ctx context.Context,
+	content []anthropic.ContentBlockParamUnion,
+	figaro *Figaro,
+	maxCalls int,
+) ([]anthropic.ContentBlockParamUnion, int, error) {
+	tracer := figaro.tracerProvider.Tracer("figaro")
+	ctx, span := tracer.Start(ctx, "callTools")
+	defer span.End()
+
+	calls := make([]toolCall, 0, len(content))
+	for _, block := range content {
+		if variant := block.OfRequestToolUseBlock; variant != nil {
+			figaro.output.Emit(Event{Type: EventToolUse, ID: variant.ID, Name: variant.Name})
+			figaro.output.Emit(Event{Type: EventToolInput, ID: variant.ID, Name: variant.Name, Input: variant.Input})
+			calls = append(calls, toolCall{block: variant})
+		}
+	}
+
+	// approval comes first, one call at a time, so that the user is not asked several questions at once.  Once the
+	// request is interrupted or out of time, nothing more is asked and the calls left are not run, and neither are
+	// those past the limit, which are not asked about either.
+	dispatched := 0
+	for i := range calls {
+		call := &calls[i]
+		if ctx.Err() != nil {
+			call.err = context.Cause(ctx)
+			continue
+		} else if dispatched >= maxCalls {
+			call.err = &ToolError{Tool: call.block.Name, Err: errToolCallLimit}
+			continue
+		}
+		if tool, ok := figaro.GetTool(call.block.Name); ok && figaro.approvals != nil {
+			err := figaro.approvals.Check(ctx, tool, rawInput(call.block.Input))
+			var denied *DeniedError
+			switch {
+			case ctx.Err() != nil:
+				call.err = context.Cause(ctx)
+			case errors.As(err, &denied):
+				call.err = denied
+			case err != nil:
+				span.AddEvent("Failed to save approval", trace.WithAttributes(attribute.String("error", err.Error())))
 			}
-			response, err := client.SendMessage(
-				ctx,
-				"tools/call",
-				mcp.CallToolRequestParams{
-					Name:      variant.Name,
-					Arguments: args,
-				})
+		}
+		if call.err == nil {
+			dispatched++
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := range calls {
+		call := &calls[i]
+		if call.err != nil {
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			args, err := toolArguments(call.block.Input)
 			if err != nil {
-				return nil, err
+				call.err = &ToolError{Tool: call.block.Name, Err: fmt.Errorf("invalid input: %w", err)}
+				return
 			}
-			tools[variant.ID] = *response
+			call.response, call.err = figaro.CallTool(ctx, call.block.Name, args)
+		}()
+	}
+	wg.Wait()
+
+	// a call cut short by the request timing out or being interrupted still gets its result, saying so, for the
+	// tool_use to have one when the session is resumed
+
+	results := make([]anthropic.ContentBlockParamUnion, 0, len(calls))
+	for _, call := range calls {
+		result, err := call.result()
+		if err != nil {
+			span.AddEvent("Tool call failed", trace.WithAttributes(
+				attribute.String("tool", call.block.Name),
+				attribute.String("tool_use_id", call.block.ID),
+				attribute.String("error", err.Error())))
+		}
+		figaro.output.Emit(Event{
+			Type:    EventToolResult,
+			ID:      call.block.ID,
+			Name:    call.block.Name,
+			Result:  result.Content,
+			IsError: err != nil,
+		})
+		results = append(results, anthropic.ContentBlockParamUnion{OfRequestToolResultBlock: &result})
+	}
+	if ctx.Err() != nil {
+		return results, dispatched, context.Cause(ctx)
+	}
+	return results, dispatched, nil
+}
+
+// The tool_result block to give the model for the call, and an error if the call failed in any way: no such tool,
+// an error response from the server, or a result flagged isError by the tool itself.
+func (call toolCall) result() (anthropic.ToolResultBlockParam, error) {
+	block := anthropic.ToolResultBlockParam{ToolUseID: call.block.ID}
+	failed := func(err error) (anthropic.ToolResultBlockParam, error) {
+		block.IsError = anthropic.Bool(true)
+		block.Content = []anthropic.ToolResultBlockParamContentUnion{{
+			OfRequestTextBlock: &anthropic.TextBlockParam{Text: err.Error()},
+		}}
+		return block, err
+	}
+
+	if call.err != nil {
+		return failed(call.err)
+	}
+	if call.response.Error != nil {
+		return failed(&ToolError{Tool: call.block.Name, Err: call.response.Error})
+	}
+	result, err := mcp.DecodeCallToolResult(call.response.Result)
+	if err != nil {
+		return failed(&ToolError{Tool: call.block.Name, Err: err})
+	}
+
+	block.Content = anthropicbridge.GetToolResultContent(result)
+	if result.IsError {
+		block.IsError = anthropic.Bool(true)
+		return block, &ToolError{Tool: call.block.Name, Err: errors.New("reported an error")}
+	}
+	return block, nil
+}
+
+// CallTool sends a tools/call request to the server offering the named tool, and returns its response as is.
+// It waits for a free slot on that server and overall first, after which the call has the tool's timeout to
+// complete.
+func (figaro *Figaro) CallTool(ctx context.Context, name string, args map[string]any) (*jsonrpc.Message[any], error) {
+	index, ok := figaro.toolIndex[name]
+	if !ok {
+		return nil, &ToolError{Tool: name, Err: fmt.Errorf("no server offers a tool named %q", name)}
+	}
+	exposed := figaro.tools[index]
+
+	release, err := acquire(ctx, exposed.client.toolSlots, figaro.toolSlots)
+	if err != nil {
+		return nil, &ToolError{Tool: name, Err: err}
+	}
+	defer release()
+
+	timeout := figaro.limits.GetToolTimeoutFor(name)
+	ctx, cancel := context.WithTimeoutCause(ctx, timeout, fmt.Errorf("%w: no response within %v", ErrTimeout, timeout))
+	defer cancel()
+
+	// the server knows the tool by its own name
+	response, err := exposed.client.mcpClient.SendMessage(
+		ctx,
+		"tools/call",
+		mcp.CallToolRequestParams{
+			Name:      exposed.originalName,
+			Arguments: args,
+		})
+	if err != nil {
+		return nil, &ToolError{Tool: name, Err: err}
+	}
+	return response, nil
+}
+
+// Takes a slot from each semaphore in turn, giving back those already taken if ctx ends first.
+func acquire(ctx context.Context, semaphores ...chan struct{}) (func(), error) {
+	release := func(taken []chan struct{}) {
+		for _, semaphore := range taken {
+			<-semaphore
+		}
+	}
+	for i, semaphore := range semaphores {
+		select {
+		case semaphore <- struct{}{}:
+		case <-ctx.Done():
+			release(semaphores[:i])
+			return nil, context.Cause(ctx)
 		}
 	}
-	return tools, nil
+	return func() { release(semaphores) }, nil
 }
 
-// Converting to string
-func anyToString(v any) string {
-	switch val := v.(type) {
-	case string:
-		return val
-	case map[string]any, []any:
-		bytes, _ := json.Marshal(val)
-		return string(bytes)
-	default:
-		return fmt.Sprintf("%v", val)
+// The input of a tool_use block as json, for showing it.
+func rawInput(input any) json.RawMessage {
+	if raw, ok := input.(json.RawMessage); ok {
+		return raw
+	}
+	raw, _ := json.Marshal(input)
+	return raw
+}
+
+// Decodes the input of a tool_use block, which holds the raw json received from the model.
+func toolArguments(input any) (map[string]any, error) {
+	raw, ok := input.(json.RawMessage)
+	if !ok {
+		var err error
+		raw, err = json.Marshal(input)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var args map[string]any
+	if err := json.Unmarshal(raw, &args); err != nil {
+		return nil, err
 	}
+	return args, nil
 }
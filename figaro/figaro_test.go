@@ -0,0 +1,97 @@
+package figaro
+
+import (
+	"context"
+	"errors"
+	"figaro/mcp"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// A figaro with one server, which takes a while to stop once ctx ends, or never does if stop is false.
+func figaroStopping(ctx context.Context, stop bool) (*Figaro, *atomic.Bool) {
+	done := make(chan error, 1)
+	stopped := &atomic.Bool{}
+	go func() {
+		<-ctx.Done()
+		if !stop {
+			return
+		}
+		time.Sleep(50 * time.Millisecond)
+		stopped.Store(true)
+		close(done)
+	}()
+	return &Figaro{clients: []mcpClientWrapper{{
+		name:       "slow",
+		connection: &lifeCycleWrapper{done: done, cancel: func() {}},
+	}}}, stopped
+}
+
+func TestCancelWaitsForTeardown(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	f, stopped := figaroStopping(ctx, true)
+
+	f.teardownOnCancel(cancel, 5*time.Second)(errors.New("shutting down"))
+	if !stopped.Load() {
+		t.Error("cancel returned before the server was stopped")
+	}
+}
+
+func TestCancelGivesUpOnTeardown(t *testing.T) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	f, _ := figaroStopping(ctx, false)
+
+	returned := make(chan struct{})
+	go func() {
+		f.teardownOnCancel(cancel, 50*time.Millisecond)(errors.New("shutting down"))
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("cancel kept waiting for a server that never stopped")
+	}
+}
+
+func TestDeniedToolCallsDoNotCount(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	f := &Figaro{
+		clients: []mcpClientWrapper{{
+			name:      "fs",
+			mcpClient: &mcp.Client{Tools: []mcp.Tool{{Name: "write"}}},
+		}},
+		tracerProvider: noop.NewTracerProvider(),
+		output:         &consoleOutput{out: io.Discard, diag: io.Discard},
+	}
+	f.indexTools()
+	// a tool without annotations is not read-only, so every call is denied without asking
+	approvals, err := NewApprovals(ApprovalReadOnly, t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.SetApprovals(approvals)
+
+	content := []anthropic.ContentBlockParamUnion{
+		{OfRequestToolUseBlock: &anthropic.ToolUseBlockParam{ID: "call_a", Name: "write", Input: map[string]any{}}},
+		{OfRequestToolUseBlock: &anthropic.ToolUseBlockParam{ID: "call_b", Name: "write", Input: map[string]any{}}},
+	}
+	results, dispatched, err := callTools(context.Background(), content, f, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dispatched != 0 {
+		t.Errorf("%d calls were counted, want none as none were run", dispatched)
+	}
+	for _, result := range results {
+		text := result.OfRequestToolResultBlock.Content[0].OfRequestTextBlock.Text
+		if !strings.Contains(text, "did not allow") {
+			t.Errorf("result = %q, want the call denied rather than over the limit", text)
+		}
+	}
+}
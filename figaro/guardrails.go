@@ -0,0 +1,93 @@
+package figaro
+
+import (
+	"context"
+	"errors"
+	"figaro/config"
+	"figaro/llm"
+	"figaro/mcp"
+	"fmt"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// What one request has used of its limits so far.
+type budget struct {
+	limits     config.Limits
+	started    time.Time
+	iterations int
+	toolCalls  int
+	usage      Usage
+}
+
+func newBudget(limits config.Limits) *budget {
+	return &budget{limits: limits, started: time.Now()}
+}
+
+// What the calls the model asks for beyond max_tool_calls get as their result.
+var errToolCallLimit = errors.New("tool call limit reached, the call was not run")
+
+// How many more tool calls the request may make.
+func (b *budget) toolCallsLeft() int {
+	return max(b.limits.GetMaxToolCalls()-b.toolCalls, 0)
+}
+
+// Says which limit has been reached, or returns "" if the tool loop may go on.
+func (b *budget) exhausted() string {
+	limits := b.limits
+	switch {
+	case b.iterations >= limits.GetMaxIterations():
+		return fmt.Sprintf("reached the limit of %d rounds of tool calls", limits.GetMaxIterations())
+	case b.toolCalls >= limits.GetMaxToolCalls():
+		return fmt.Sprintf("reached the limit of %d tool calls", limits.GetMaxToolCalls())
+	case limits.MaxInputTokens > 0 && b.usage.TotalInputTokens() >= limits.MaxInputTokens:
+		return fmt.Sprintf("used %d of %d input tokens", b.usage.TotalInputTokens(), limits.MaxInputTokens)
+	case limits.MaxOutputTokens > 0 && b.usage.OutputTokens >= limits.MaxOutputTokens:
+		return fmt.Sprintf("used %d of %d output tokens", b.usage.OutputTokens, limits.MaxOutputTokens)
+	case limits.MaxDuration > 0 && time.Since(b.started) >= time.Duration(limits.MaxDuration):
+		return fmt.Sprintf("ran for longer than %v", time.Duration(limits.MaxDuration))
+	default:
+		return ""
+	}
+}
+
+// Ends a request that ran out of budget by asking the model, which is not allowed any more tools, to sum up what
+// it has done and what is left.  The request goes through the same turn as any other, so the summary is streamed
+// and recorded like any reply.
+func (figaro *Figaro) summarize(
+	ctx context.Context,
+	bridge llm.Provider,
+	session *Session,
+	tools []mcp.Tool,
+	b *budget,
+	reason string,
+) error {
+	span := trace.SpanFromContext(ctx)
+	span.AddEvent("Request stopped by a limit", trace.WithAttributes(attribute.String("reason", reason)))
+	figaro.output.Emit(Event{Type: EventLimit, Text: reason})
+
+	// the last turn is the user's, holding the tool results, so the note goes along with them
+	last := &session.Messages[len(session.Messages)-1]
+	last.Content = append(last.Content, anthropic.NewTextBlock(fmt.Sprintf(
+		"[figaro] This request has %s, so no more tools can be called. Sum up what you found and did so far, "+
+			"and what is left to do.", reason)))
+
+	if err := figaro.compact(ctx, bridge, session, tools, b); err != nil {
+		return err
+	}
+	content, stopReason, err := figaro.streamTurn(ctx, bridge, session.Messages, tools, b, withoutTools)
+	if err != nil && !errors.Is(err, ErrInterrupted) {
+		return err
+	}
+	figaro.addReply(session, content, stopReason)
+	return err
+}
+
+// Keeps the tool definitions, which the api needs to make sense of earlier tool_use blocks, but does not let the
+// model use them.
+func withoutTools(params *anthropic.MessageNewParams) {
+	params.ToolChoice = anthropic.ToolChoiceUnionParam{OfToolChoiceNone: &anthropic.ToolChoiceNoneParam{}}
+}
@@ -0,0 +1,76 @@
+package figaro
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const instructionsFile = "instructions.md"
+
+// Standing instructions for the model, read from an instructions.md file.
+type Instructions struct {
+	Path string
+	Text string
+}
+
+// FindInstructions collects the global ~/.figaro/instructions.md followed by every .figaro/instructions.md found
+// from the filesystem root down to dir, so that the instructions closest to dir come last and read as the most
+// specific.
+func FindInstructions(dir string) ([]Instructions, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := make([]string, 0)
+	if homeDir, err := os.UserHomeDir(); err == nil {
+		candidates = append(candidates, filepath.Join(homeDir, ".figaro", instructionsFile))
+	}
+
+	projectCandidates := make([]string, 0)
+	for {
+		projectCandidates = append(projectCandidates, filepath.Join(dir, ".figaro", instructionsFile))
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			break
+		}
+		dir = parent
+	}
+	for i := len(projectCandidates) - 1; i >= 0; i-- {
+		candidates = append(candidates, projectCandidates[i])
+	}
+
+	seen := make(map[string]bool, len(candidates))
+	instructions := make([]Instructions, 0)
+	for _, path := range candidates {
+		// the global file is found again while walking up if we are somewhere under the home directory
+		if seen[path] {
+			continue
+		}
+		seen[path] = true
+
+		found, err := ReadInstructions(path)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		if found.Text != "" {
+			instructions = append(instructions, *found)
+		}
+	}
+	return instructions, nil
+}
+
+func ReadInstructions(path string) (*Instructions, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Instructions{
+		Path: path,
+		Text: strings.TrimSpace(string(data)),
+	}, nil
+}
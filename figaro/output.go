@@ -0,0 +1,266 @@
+package figaro
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"golang.org/x/term"
+)
+
+type EventType string
+
+const (
+	EventTextDelta EventType = "text_delta"
+	// Some of what the model thinks before replying in Text, or of the thinking block's Signature
+	EventThinkingDelta EventType = "thinking_delta"
+	// The model started calling a tool, whose input follows in tool_input_delta events
+	EventToolUseStart EventType = "tool_use_start"
+	// More of the input of a tool call as it is generated, a piece of json in Text
+	EventToolInputDelta EventType = "tool_input_delta"
+	// A tool call is about to run, with the whole of its input in the tool_input event that follows
+	EventToolUse    EventType = "tool_use"
+	EventToolInput  EventType = "tool_input"
+	EventToolResult EventType = "tool_result"
+	// What a turn used, Usage says
+	EventUsage   EventType = "usage"
+	EventMessage EventType = "message"
+	// A limit of the request was reached, Text says which; the model is asked to sum up next
+	EventLimit EventType = "limit"
+	// Older turns were compacted to fit the context window, Text says how
+	EventCompact EventType = "compact"
+	// A request is done: Usage is what it used in all, SessionUsage what the session has used so far
+	EventTotal EventType = "total"
+	EventError EventType = "error"
+)
+
+// Something that happened while serving a request, reported to the Output as it happens.
+type Event struct {
+	Type         EventType                   `json:"type"`
+	Text         string                      `json:"text,omitempty"`
+	Signature    string                      `json:"signature,omitempty"`
+	ID           string                      `json:"id,omitempty"`
+	Name         string                      `json:"name,omitempty"`
+	Input        any                         `json:"input,omitempty"`
+	Result       any                         `json:"result,omitempty"`
+	IsError      bool                        `json:"is_error,omitempty"`
+	Usage        *Usage                      `json:"usage,omitempty"`
+	SessionUsage *Usage                      `json:"session_usage,omitempty"`
+	Message      *anthropic.MessageParam     `json:"message,omitempty"`
+	StopReason   anthropic.MessageStopReason `json:"stop_reason,omitempty"`
+	Error        string                      `json:"error,omitempty"`
+	ErrorKind    ErrorKind                   `json:"error_kind,omitempty"`
+}
+
+// Renders what figaro does, for a person or for a script.
+type Output interface {
+	Emit(event Event)
+	// Called once the last request has been served, with the error it failed with if any.
+	Finish(session *Session, err error) error
+}
+
+type OutputMode string
+
+const (
+	OutputText   OutputMode = "text"
+	OutputJSON   OutputMode = "json"
+	OutputNDJSON OutputMode = "ndjson"
+)
+
+func NewOutput(mode OutputMode) (Output, error) {
+	switch mode {
+	case OutputText, "":
+		return &consoleOutput{out: os.Stdout, diag: os.Stderr, dim: term.IsTerminal(int(os.Stderr.Fd()))}, nil
+	case OutputJSON:
+		return &jsonOutput{out: os.Stdout}, nil
+	case OutputNDJSON:
+		return &ndjsonOutput{encoder: json.NewEncoder(os.Stdout)}, nil
+	default:
+		return nil, fmt.Errorf("unknown output mode %q, expected text, json or ndjson", mode)
+	}
+}
+
+// Streams the reply as plain text, with tool activity and thinking noted on stderr.
+type consoleOutput struct {
+	out  io.Writer
+	diag io.Writer
+	// whether diag is a terminal that thinking can be shown dimmed on
+	dim bool
+	// whether thinking is being shown
+	thinking bool
+}
+
+const (
+	ansiDim   = "\x1b[2m"
+	ansiReset = "\x1b[0m"
+)
+
+func (o *consoleOutput) Emit(event Event) {
+	if event.Type != EventThinkingDelta && o.thinking {
+		o.thinking = false
+		if o.dim {
+			fmt.Fprint(o.diag, ansiReset)
+		}
+		fmt.Fprint(o.diag, "\n\n")
+	}
+
+	switch event.Type {
+	case EventThinkingDelta:
+		if event.Text == "" {
+			return
+		}
+		if !o.thinking {
+			o.thinking = true
+			fmt.Fprint(o.diag, "[thinking]\n")
+			if o.dim {
+				fmt.Fprint(o.diag, ansiDim)
+			}
+		}
+		fmt.Fprint(o.diag, event.Text)
+	case EventTextDelta:
+		fmt.Fprint(o.out, event.Text)
+	case EventToolUseStart:
+		fmt.Fprintf(o.diag, "\n[tool_use %s]\n", event.Name)
+	case EventToolResult:
+		if event.IsError {
+			fmt.Fprintf(o.diag, "[tool_result %s failed]\n", event.Name)
+		}
+	case EventLimit:
+		fmt.Fprintf(o.diag, "\n[stopping: %s, asking for a summary]\n", event.Text)
+	case EventCompact:
+		fmt.Fprintf(o.diag, "\n[compacted the conversation: %s]\n", event.Text)
+	case EventTotal:
+		usage := event.Usage
+		fmt.Fprintf(o.diag, "[%d tokens in, %d read from cache, %d written to it; %d out; %s, session %s]\n",
+			usage.TotalInputTokens(), usage.CacheReadInputTokens, usage.CacheCreationInputTokens, usage.OutputTokens,
+			usage.FormatCost(), event.SessionUsage.FormatCost())
+	case EventMessage:
+		if event.StopReason != anthropic.MessageStopReasonToolUse {
+			fmt.Fprintln(o.out)
+		}
+	}
+}
+
+func (o *consoleOutput) Finish(session *Session, err error) error {
+	if err != nil {
+		fmt.Fprintln(o.diag, err)
+	}
+	return nil
+}
+
+// Writes every event as a line of json.
+type ndjsonOutput struct {
+	encoder *json.Encoder
+}
+
+func (o *ndjsonOutput) Emit(event Event) {
+	o.encoder.Encode(event)
+}
+
+func (o *ndjsonOutput) Finish(session *Session, err error) error {
+	if err != nil {
+		o.Emit(Event{Type: EventError, Error: err.Error(), ErrorKind: ClassifyError(err)})
+	}
+	return nil
+}
+
+// Stays silent until the end, then writes the whole transcript as a single json document.
+type jsonOutput struct {
+	out   io.Writer
+	usage Usage
+}
+
+type jsonTranscript struct {
+	Session  string                   `json:"session"`
+	Model    anthropic.Model          `json:"model"`
+	Messages []anthropic.MessageParam `json:"messages"`
+	Usage    Usage                    `json:"usage"`
+	// What the session has used over every request, this one included
+	SessionUsage Usage     `json:"session_usage"`
+	Error        string    `json:"error,omitempty"`
+	ErrorKind    ErrorKind `json:"error_kind,omitempty"`
+}
+
+func (o *jsonOutput) Emit(event Event) {
+	if event.Type == EventTotal {
+		o.usage.Add(*event.Usage)
+	}
+}
+
+func (o *jsonOutput) Finish(session *Session, err error) error {
+	transcript := jsonTranscript{
+		Messages: []anthropic.MessageParam{},
+		Usage:    o.usage,
+	}
+	if session != nil {
+		transcript.Session = session.Name
+		transcript.Model = session.Model
+		transcript.Messages = session.Messages
+		transcript.SessionUsage = session.Usage
+	}
+	if err != nil {
+		transcript.Error = err.Error()
+		transcript.ErrorKind = ClassifyError(err)
+	}
+	encoder := json.NewEncoder(o.out)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(transcript)
+}
+
+type ErrorKind string
+
+const (
+	ErrorKindModel       ErrorKind = "model"
+	ErrorKindTool        ErrorKind = "tool"
+	ErrorKindTimeout     ErrorKind = "timeout"
+	ErrorKindInterrupted ErrorKind = "interrupted"
+	ErrorKindOther       ErrorKind = "other"
+)
+
+var ErrTimeout = errors.New("operation timed out")
+
+// The cause to cancel a request's context with to interrupt it.  What the model had said so far and the results
+// of the tool calls cut short are kept in the conversation, which can go on from there.
+var ErrInterrupted = errors.New("interrupted by the user")
+
+// The model could not be reached or refused the request.
+type ModelError struct {
+	Err error
+}
+
+func (e *ModelError) Error() string { return fmt.Sprintf("model error: %v", e.Err) }
+func (e *ModelError) Unwrap() error { return e.Err }
+
+// A tool call could not be carried out.
+type ToolError struct {
+	Tool string
+	Err  error
+}
+
+func (e *ToolError) Error() string { return fmt.Sprintf("tool %s failed: %v", e.Tool, e.Err) }
+func (e *ToolError) Unwrap() error { return e.Err }
+
+// ClassifyError tells what kind of failure an error returned by Request is.  Timeouts take precedence, since they
+// surface through both the model and the tools.
+func ClassifyError(err error) ErrorKind {
+	var modelError *ModelError
+	var toolError *ToolError
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrInterrupted):
+		return ErrorKindInterrupted
+	case errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return ErrorKindTimeout
+	case errors.As(err, &toolError):
+		return ErrorKindTool
+	case errors.As(err, &modelError):
+		return ErrorKindModel
+	default:
+		return ErrorKindOther
+	}
+}
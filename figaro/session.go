@@ -0,0 +1,193 @@
+package figaro
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"figaro/anthropicbridge"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// A named conversation persisted under ~/.figaro/sessions so that it can be resumed by a later run.
+type Session struct {
+	Name      string                   `json:"name"`
+	Model     anthropic.Model          `json:"model"`
+	CreatedAt time.Time                `json:"created_at"`
+	UpdatedAt time.Time                `json:"updated_at"`
+	Messages  []anthropic.MessageParam `json:"messages"`
+	// What every request of the session used so far.
+	Usage Usage `json:"usage,omitzero"`
+}
+
+var ErrSessionNotFound = errors.New("session not found")
+
+var sessionNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]+$`)
+
+// NewSession returns an empty session.  If name is empty, one is derived from the current time, with a random
+// suffix so that runs started within the same second do not write over each other's session.
+func NewSession(name string) (*Session, error) {
+	now := time.Now()
+	if name == "" {
+		suffix := make([]byte, 3)
+		if _, err := rand.Read(suffix); err != nil {
+			return nil, err
+		}
+		name = now.Format("2006-01-02T15-04-05") + "-" + hex.EncodeToString(suffix)
+	}
+	if !sessionNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid session name %q: only letters, digits, '_', '.' and '-' are allowed", name)
+	}
+	return &Session{
+		Name:      name,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+func (s *Session) UnmarshalJSON(data []byte) error {
+	type shadow Session
+	var raw struct {
+		shadow
+		Messages json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*s = Session(raw.shadow)
+	if len(raw.Messages) == 0 || string(raw.Messages) == "null" {
+		return nil
+	}
+	messages, err := anthropicbridge.UnmarshalConversation(raw.Messages)
+	if err != nil {
+		return fmt.Errorf("session %s: %w", s.Name, err)
+	}
+	s.Messages = messages
+	return nil
+}
+
+// Save writes the session to its file, creating the sessions directory if needed.
+func (s *Session) Save() error {
+	dir, err := sessionDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	s.UpdatedAt = time.Now()
+	byteContents, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, s.Name+".json"), byteContents, 0600)
+}
+
+// LoadSession reads the session with the provided name.  Returns ErrSessionNotFound if there is none.
+func LoadSession(name string) (*Session, error) {
+	if !sessionNamePattern.MatchString(name) {
+		return nil, fmt.Errorf("invalid session name %q", name)
+	}
+	dir, err := sessionDir()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrSessionNotFound, name)
+	} else if err != nil {
+		return nil, err
+	}
+
+	var session Session
+	if err := json.Unmarshal(data, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// LoadOrCreateSession resumes the named session, or starts it if it does not exist yet.
+func LoadOrCreateSession(name string) (*Session, error) {
+	session, err := LoadSession(name)
+	if errors.Is(err, ErrSessionNotFound) {
+		return NewSession(name)
+	}
+	return session, err
+}
+
+// ListSessions returns every saved session, most recently updated first.  Sessions that cannot be read are left out
+// with a warning, rather than keeping the others from being listed or resumed.
+func ListSessions() ([]*Session, error) {
+	dir, err := sessionDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []*Session{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*Session, 0, len(entries))
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok {
+			continue
+		}
+		session, err := LoadSession(name)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: skipping session %s: %v\n", name, err)
+			continue
+		}
+		sessions = append(sessions, session)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].UpdatedAt.After(sessions[j].UpdatedAt)
+	})
+	return sessions, nil
+}
+
+// LatestSession returns the most recently updated session.  Returns ErrSessionNotFound if there is none.
+func LatestSession() (*Session, error) {
+	sessions, err := ListSessions()
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, ErrSessionNotFound
+	}
+	return sessions[0], nil
+}
+
+// DeleteSession removes the named session's file.
+func DeleteSession(name string) error {
+	if !sessionNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid session name %q", name)
+	}
+	dir, err := sessionDir()
+	if err != nil {
+		return err
+	}
+	err = os.Remove(filepath.Join(dir, name+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%w: %s", ErrSessionNotFound, name)
+	}
+	return err
+}
+
+func sessionDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".figaro", "sessions"), nil
+}
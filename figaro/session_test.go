@@ -0,0 +1,50 @@
+package figaro
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewSessionNamesDiffer(t *testing.T) {
+	first, err := NewSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := NewSession("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Name == second.Name {
+		t.Errorf("two sessions started at once are both named %s", first.Name)
+	}
+}
+
+func TestListSessionsSkipsUnreadable(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	session, err := NewSession("good")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Save(); err != nil {
+		t.Fatal(err)
+	}
+	dir, err := sessionDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "broken.json"), []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, err := ListSessions()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Name != "good" {
+		t.Errorf("listed %d sessions, want only the readable one", len(sessions))
+	}
+	if latest, err := LatestSession(); err != nil || latest.Name != "good" {
+		t.Errorf("LatestSession() = %v, %v, want the readable one", latest, err)
+	}
+}
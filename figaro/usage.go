@@ -0,0 +1,161 @@
+package figaro
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"figaro/config"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tokens used over one or more turns, and what they cost.
+type Usage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+	// In US dollars.
+	Cost float64 `json:"cost_usd"`
+	// Some of the tokens were used by a model without a price, so Cost falls short.
+	Unpriced bool `json:"unpriced,omitempty"`
+}
+
+// Prices what a turn of model used.
+func priceUsage(usage anthropic.Usage, model anthropic.Model, prices map[string]config.Price) Usage {
+	priced := Usage{
+		InputTokens:              usage.InputTokens,
+		OutputTokens:             usage.OutputTokens,
+		CacheCreationInputTokens: usage.CacheCreationInputTokens,
+		CacheReadInputTokens:     usage.CacheReadInputTokens,
+	}
+	price, ok := prices[model]
+	if !ok {
+		priced.Unpriced = true
+		return priced
+	}
+	priced.Cost = price.Cost(usage.InputTokens, usage.OutputTokens, usage.CacheCreationInputTokens, usage.CacheReadInputTokens)
+	return priced
+}
+
+// Add counts other in u.
+func (u *Usage) Add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheCreationInputTokens += other.CacheCreationInputTokens
+	u.CacheReadInputTokens += other.CacheReadInputTokens
+	u.Cost += other.Cost
+	u.Unpriced = u.Unpriced || other.Unpriced
+}
+
+// TotalInputTokens counts the input tokens, whether they were read from the cache, written to it or neither.
+func (u Usage) TotalInputTokens() int64 {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
+}
+
+// FormatCost renders the cost in dollars, marked with a '+' when some usage could not be priced.
+func (u Usage) FormatCost() string {
+	cost := fmt.Sprintf("$%.4f", u.Cost)
+	if u.Unpriced {
+		cost += "+"
+	}
+	return cost
+}
+
+// What one request used, as kept in ~/.figaro/usage.jsonl for the usage report.
+type UsageRecord struct {
+	Time    time.Time       `json:"time"`
+	Session string          `json:"session"`
+	Model   anthropic.Model `json:"model"`
+	Usage
+}
+
+// Appends a request's usage to the log.
+func recordUsage(record UsageRecord) error {
+	path, err := usagePath()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadUsage reads the usage of every request recorded so far, oldest first.
+func LoadUsage() ([]UsageRecord, error) {
+	path, err := usagePath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []UsageRecord{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records := make([]UsageRecord, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var record UsageRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+func usagePath() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".figaro", "usage.jsonl"), nil
+}
+
+// Prices what a turn used and counts it against the request's budget.
+func (figaro *Figaro) countUsage(b *budget, usage anthropic.Usage) {
+	priced := priceUsage(usage, figaro.model, figaro.prices)
+	b.usage.Add(priced)
+	figaro.output.Emit(Event{Type: EventUsage, Usage: &priced})
+}
+
+// Adds what a request used to the session's totals and to the usage log, and reports both totals.
+func (figaro *Figaro) finishUsage(ctx context.Context, session *Session, usage Usage) {
+	if usage == (Usage{}) {
+		return
+	}
+	session.Usage.Add(usage)
+	err := recordUsage(UsageRecord{Time: time.Now(), Session: session.Name, Model: figaro.model, Usage: usage})
+	if err != nil {
+		trace.SpanFromContext(ctx).AddEvent("Failed to record usage",
+			trace.WithAttributes(attribute.String("error", err.Error())))
+	}
+	sessionUsage := session.Usage
+	figaro.output.Emit(Event{Type: EventTotal, Usage: &usage, SessionUsage: &sessionUsage})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"figaro/figaro"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// What the context everything runs under is cancelled with.  It is an interruption too, so that a request cut
+// short by it keeps what came of it.
+var errShutdown = fmt.Errorf("shutting down: %w", figaro.ErrInterrupted)
+
+// Turns Ctrl-C into cancellations.  The first one interrupts the request in flight, keeping what came of it in the
+// session; the next one, or one while no request is in flight, shuts figaro down, which waits for the MCP servers to
+// stop on the way out.  Should that hang, one more exits at once.
+type interrupter struct {
+	mu sync.Mutex
+	// cancels the request in flight, nil between requests
+	cancelRequest context.CancelCauseFunc
+	interrupted   bool
+	shutdown      context.CancelCauseFunc
+	shuttingDown  bool
+}
+
+// Starts listening for Ctrl-C until stop is called.  shutdown cancels the context everything runs under.
+func handleInterrupts(shutdown context.CancelCauseFunc) (i *interrupter, stop func()) {
+	i = &interrupter{shutdown: shutdown}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case <-signals:
+				i.interrupt()
+			case <-done:
+				return
+			}
+		}
+	}()
+	return i, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+func (i *interrupter) interrupt() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	switch {
+	case i.cancelRequest != nil && !i.interrupted:
+		i.interrupted = true
+		i.cancelRequest(figaro.ErrInterrupted)
+		fmt.Fprintln(os.Stderr, "\n[interrupted, Ctrl-C again to quit]")
+	case !i.shuttingDown:
+		i.shuttingDown = true
+		i.shutdown(errShutdown)
+		fmt.Fprintln(os.Stderr, "\n[shutting down, Ctrl-C again to exit at once]")
+	default:
+		os.Exit(exitInterrupted)
+	}
+}
+
+// Returns the context to serve a request under, which the next Ctrl-C cancels, and the function to call once the
+// request is done.
+func (i *interrupter) request(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	i.mu.Lock()
+	i.cancelRequest = cancel
+	i.interrupted = false
+	i.mu.Unlock()
+	return ctx, func() {
+		i.mu.Lock()
+		i.cancelRequest = nil
+		i.mu.Unlock()
+		cancel(nil)
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"figaro/figaro"
+	"testing"
+)
+
+func TestInterruptThenShutDown(t *testing.T) {
+	root, shutdown := context.WithCancelCause(context.Background())
+	i := &interrupter{shutdown: shutdown}
+	requestCtx, requestDone := i.request(root)
+	defer requestDone()
+
+	i.interrupt()
+	if !errors.Is(context.Cause(requestCtx), figaro.ErrInterrupted) {
+		t.Errorf("the request ended with %v, want it interrupted", context.Cause(requestCtx))
+	}
+	if root.Err() != nil {
+		t.Fatal("the first Ctrl-C shut figaro down")
+	}
+
+	i.interrupt()
+	if !errors.Is(context.Cause(root), errShutdown) {
+		t.Errorf("figaro ended with %v, want it shut down", context.Cause(root))
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"os"
 	"strings"
 	"sync"
 	"time"
@@ -22,6 +23,7 @@ type Client interface {
 }
 
 type StdioClient struct {
+	timeout               time.Duration
 	reader                io.Reader
 	conn                  net.Conn
 	notificaticationChans map[string]chan Message[any]
@@ -29,6 +31,9 @@ type StdioClient struct {
 	tracerProvider        trace.TracerProvider
 	notLock               *sync.RWMutex
 	resLock               *sync.RWMutex
+	// tells the server about requests given up on, if set
+	cancelMethod string
+	cancelParams func(request Message[any], cause error) (any, bool)
 }
 
 type Connection struct {
@@ -49,23 +54,25 @@ func (client *StdioClient) SendActionMessage(ctx context.Context, method string)
 	tracer := client.tracerProvider.Tracer("jsonrpc")
 	ctx, span := tracer.Start(ctx, "SendMessage")
 	defer span.End()
-	return client.sendMessage(Message[any]{JSONRPC: "2.0", Method: method})
+	return client.sendMessage(ctx, Message[any]{JSONRPC: "2.0", Method: method})
 }
 
 func (client *StdioClient) SendMessage(ctx context.Context, method string, params any) (*Message[any], error) {
 	tracer := client.tracerProvider.Tracer("jsonrpc")
 	ctx, span := tracer.Start(ctx, "SendMessage")
 	defer span.End()
-	return client.sendMessage(Message[any]{JSONRPC: "2.0", Method: method, Params: params})
+	return client.sendMessage(ctx, Message[any]{JSONRPC: "2.0", Method: method, Params: params})
 }
 
 // optional notification chan for auxiliary messages besides the response
 // generates an id on behalf of the user if it is not provided
-func (client *StdioClient) sendMessage(message Message[any]) (*Message[any], error) {
+// gives up when ctx is done, or after the client's timeout if ctx has no deadline of its own
+func (client *StdioClient) sendMessage(ctx context.Context, message Message[any]) (*Message[any], error) {
 	id := uuid.New().String()
 	message.ID = id
 
-	resCh := make(chan Message[any])
+	// buffered, so that a response arriving after we gave up does not hold up the others
+	resCh := make(chan Message[any], 1)
 	client.resLock.Lock()
 	client.responseChans[id] = resCh
 	client.resLock.Unlock()
@@ -77,7 +84,7 @@ func (client *StdioClient) sendMessage(message Message[any]) (*Message[any], err
 		client.resLock.Unlock()
 	}()
 
-	errCh := make(chan error)
+	errCh := make(chan error, 1)
 	go func() {
 		err := notifyMessage(message, client.conn)
 		if err != nil {
@@ -85,17 +92,74 @@ func (client *StdioClient) sendMessage(message Message[any]) (*Message[any], err
 		}
 	}()
 
+	var timeout <-chan time.Time
+	if _, ok := ctx.Deadline(); !ok {
+		timer := time.NewTimer(client.timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
 	select {
 	case resp := <-resCh:
 		return &resp, nil
 	case err := <-errCh:
 		return nil, err
-	case <-time.After(10 * time.Second):
-		return nil, fmt.Errorf("request timed out after %v seconds", 10)
+	case <-ctx.Done():
+		client.abandon(message, context.Cause(ctx))
+		return nil, context.Cause(ctx)
+	case <-timeout:
+		err := fmt.Errorf("request timed out after %v", client.timeout)
+		client.abandon(message, err)
+		return nil, err
+	}
+}
+
+// Lets the server know that nobody waits for the response to the request any more, so that it can stop working on
+// it.  This is only a courtesy, so failing to send it is not an error.
+func (client *StdioClient) abandon(request Message[any], cause error) {
+	if client.cancelMethod == "" {
+		return
+	}
+	if params, ok := client.cancelParams(request, cause); ok {
+		notifyMessage(Message[any]{JSONRPC: "2.0", Method: client.cancelMethod, Params: params}, client.conn)
+	}
+}
+
+const DefaultTimeout = 10 * time.Second
+
+type Opts struct {
+	timeout      time.Duration
+	cancelMethod string
+	cancelParams func(request Message[any], cause error) (any, bool)
+}
+
+type OptsFunc func(o *Opts)
+
+// Changes how long a request waits for its response.
+func WithTimeout(timeout time.Duration) OptsFunc {
+	return func(o *Opts) {
+		o.timeout = timeout
+	}
+}
+
+// Sends a notification to the server when a request is given up on, because its context ended or it timed out,
+// such as MCP's notifications/cancelled.  params builds the notification's params for the request, or returns false
+// for requests that must not be cancelled.
+func WithCancelNotification(method string, params func(request Message[any], cause error) (any, bool)) OptsFunc {
+	return func(o *Opts) {
+		o.cancelMethod = method
+		o.cancelParams = params
 	}
 }
 
-func NewStdioClient[TId comparable](ctx context.Context, client *Connection, tp trace.TracerProvider) (*StdioClient, <-chan error, error) {
+func NewStdioClient[TId comparable](ctx context.Context, client *Connection, tp trace.TracerProvider, opts ...OptsFunc) (*StdioClient, <-chan error, error) {
+	o := Opts{
+		timeout: DefaultTimeout,
+	}
+	for _, optFunc := range opts {
+		optFunc(&o)
+	}
+
 	ctx, cancel := context.WithCancelCause(ctx)
 	// One go routine to process the output of the conn, which sends a message over a channel to:
 	// A multiplexer below to fan out to at most three listeners
@@ -129,12 +193,13 @@ func NewStdioClient[TId comparable](ctx context.Context, client *Connection, tp
 				}
 
 				SendChannel(&notLock, notificationChannels, response.Method, response)
-				SendChannel(&resLock, responseChans, response.ID, response)
+				deliverResponse(&resLock, responseChans, response)
 			}
 		}
 	}()
 
 	return &StdioClient{
+		timeout:               o.timeout,
 		reader:                client.Reader,
 		conn:                  client.Conn,
 		notificaticationChans: notificationChannels,
@@ -142,6 +207,8 @@ func NewStdioClient[TId comparable](ctx context.Context, client *Connection, tp
 		resLock:               &resLock,
 		responseChans:         responseChans,
 		tracerProvider:        tp,
+		cancelMethod:          o.cancelMethod,
+		cancelParams:          o.cancelParams,
 	}, doneCh, nil
 }
 
@@ -155,6 +222,19 @@ func SendChannel(notLock *sync.RWMutex, chans map[string]chan Message[any], key
 	}
 }
 
+// Hands a response to the request waiting for it, if it is still waiting.  The lock is held while sending so that
+// the channel cannot be closed under us.
+func deliverResponse(resLock *sync.RWMutex, chans map[string]chan Message[any], response Message[any]) {
+	resLock.Lock()
+	defer resLock.Unlock()
+	if resCh, exists := chans[response.ID]; exists {
+		select {
+		case resCh <- response:
+		default:
+		}
+	}
+}
+
 func processOutput(ctx context.Context, reader io.Reader, responseChan chan Message[any], cancel context.CancelCauseFunc) {
 	// Process stdout for JSON messages
 	scanner := bufio.NewScanner(reader)
@@ -173,7 +253,7 @@ func processOutput(ctx context.Context, reader io.Reader, responseChan chan Mess
 			// Try to parse as JSON
 			var response Message[any]
 			if err := json.Unmarshal([]byte(clean), &response); err != nil {
-				fmt.Println(err)
+				fmt.Fprintln(os.Stderr, err)
 				// Probably shouldn't take the entire server down on one bad json input.
 				// Instead, we should probably log the contents of the pipe someplace for debug to see why the json
 				// could not be unmarshalled.
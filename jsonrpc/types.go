@@ -1,5 +1,7 @@
 package jsonrpc
 
+import "fmt"
+
 type Message[TParams any] struct {
 	JSONRPC string  `json:"jsonrpc"`
 	ID      string  `json:"id,omitempty"`
@@ -14,3 +16,7 @@ type Error struct {
 	Message string `json:"message"`
 	Data    any    `json:"data,omitempty"`
 }
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"context"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// A model API that figaro sends requests to.  Conversations are kept in the Anthropic format, which is the richest
+// of the lot, and providers speaking another protocol translate to and from it.
+type Provider interface {
+	// Streams the reply to a request as it is generated.  The events end with StreamMessageStop, carrying the
+	// whole message, or StreamError, after which the channel is closed.
+	StreamMessage(ctx context.Context, params anthropic.MessageNewParams) (<-chan StreamEvent, error)
+	// Sends a request and waits for the whole reply, for replies that are not shown as they come.
+	NewMessage(ctx context.Context, params anthropic.MessageNewParams) (*anthropic.Message, error)
+}
+
+type StreamEventType string
+
+const (
+	// The reply has started, ID is the message's and Usage what the prompt took
+	StreamMessageStart StreamEventType = "message_start"
+	// More of the text block at Index
+	StreamTextDelta StreamEventType = "text_delta"
+	// More of the thinking block at Index, in Text, or of its Signature, which the API checks it against when
+	// the block is sent back
+	StreamThinkingDelta StreamEventType = "thinking_delta"
+	// The model started calling the tool Name at Index, its id being ID
+	StreamToolUseStart StreamEventType = "tool_use_start"
+	// More of the input of the tool call at Index, as a piece of json that is only whole once the block stops
+	StreamInputJSONDelta StreamEventType = "input_json_delta"
+	// The block at Index is done
+	StreamContentBlockStop StreamEventType = "content_block_stop"
+	// What the reply has used so far, in Usage
+	StreamUsage StreamEventType = "usage"
+	// The reply is done, Message is all of it
+	StreamMessageStop StreamEventType = "message_stop"
+	// The stream failed with Err; what came before it is all there is of the reply
+	StreamError StreamEventType = "error"
+)
+
+// Something that happened in a streamed reply.  Which fields are set depends on Type.
+type StreamEvent struct {
+	Type      StreamEventType
+	Index     int
+	Text      string
+	Signature string
+	ID        string
+	Name      string
+	Usage     anthropic.Usage
+	Message   *anthropic.Message
+	Err       error
+}
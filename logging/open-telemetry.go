@@ -42,6 +42,31 @@ func WithServiceName(name string) LoggingOptFunc {
 	}
 }
 
+// Writes traces to the provided file instead of the default location.
+func WithFilename(filename string) LoggingOptFunc {
+	return func(opts *LoggingOpts) {
+		opts.Filename = filename
+	}
+}
+
+// Changes how the trace file is rotated.  Zero values keep the defaults.
+func WithRotation(maxSizeMB int, maxAgeDays int, maxBackups int, compress *bool) LoggingOptFunc {
+	return func(opts *LoggingOpts) {
+		if maxSizeMB > 0 {
+			opts.MaxSize = maxSizeMB
+		}
+		if maxAgeDays > 0 {
+			opts.MaxAge = maxAgeDays
+		}
+		if maxBackups > 0 {
+			opts.MaxBackups = maxBackups
+		}
+		if compress != nil {
+			opts.Compress = *compress
+		}
+	}
+}
+
 func InitTracer(opts ...LoggingOptFunc) (*trace.TracerProvider, error) {
 	o := defaultOpts()
 	for _, fn := range opts {
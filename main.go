@@ -4,23 +4,68 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"figaro/anthropicbridge"
+	"figaro/config"
 	"figaro/figaro"
 	"figaro/logging"
 	"flag"
 	"fmt"
 	"os"
-	"path/filepath"
+	"strings"
 	"time"
 )
 
+// Exit codes, so that scripts can tell what went wrong.
+const (
+	exitOK      = 0
+	exitError   = 1
+	exitModel   = 2
+	exitTool    = 3
+	exitTimeout = 4
+	// as shells report a process ended by SIGINT
+	exitInterrupted = 130
+)
+
 func main() {
+	os.Exit(run())
+}
+
+func run() int {
 	// establish root context
 	ctx, cancel := context.WithCancelCause(context.Background())
 	defer cancel(ctx.Err())
 
+	// Define flag with default value "default_value"
+	modePtr := flag.String("m", anthropicbridge.DefaultModel, "Specify the `model` to use, by id, sdk constant, alias or provider:model (run 'figaro models' to list them)")
+	sessionPtr := flag.String("session", "", "Record the conversation in the named session, resuming it if it exists")
+	continuePtr := flag.Bool("continue", false, "Resume the most recently used session")
+	systemPtr := flag.String("system", "", "System prompt, added after any discovered instructions")
+	systemFilePtr := flag.String("system-file", "", "Read the system prompt from `file`")
+	outputPtr := flag.String("output", string(figaro.OutputText), "Output `mode`: text, json (final transcript) or ndjson (event stream)")
+	var files stringList
+	flag.Var(&files, "f", "Attach a text, image or PDF `file` to the prompt, or stdin if '-'; may be repeated")
+	yesPtr := flag.Bool("yes", false, "Run every tool without asking for approval")
+	readOnlyPtr := flag.Bool("read-only", false, "Only run tools that declare themselves read-only, without asking")
+	generationFlags := registerGenerationFlags()
+
+	// Parse flags
+	flag.Parse()
+	args := flag.Args()
+
+	// the config command must still work when the configuration is broken, that being what it helps fix
+	isConfigCommand := len(args) > 0 && args[0] == "config"
+	cfg, err := loadConfig(generationFlags)
+	if err != nil && !isConfigCommand {
+		logging.EzPrint(err.Error())
+		return exitError
+	}
+
 	// setup tracer and defer cleanup
-	tp, err := logging.InitTracer(logging.WithServiceName("figaro"))
+	tp, err := logging.InitTracer(loggingOpts(cfg)...)
+	if err != nil {
+		logging.EzPrint(err.Error())
+		return exitError
+	}
 	defer func() {
 		shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
 		defer shutdownCancel()
@@ -29,53 +74,301 @@ func main() {
 		}
 	}()
 
-	// Define flag with default value "default_value"
-	modePtr := flag.String("m", "ModelClaude3_7SonnetLatest", "Specify the model to use")
+	if len(args) > 0 {
+		if command, ok := commands[args[0]]; ok {
+			if err := command.run(ctx, tp, cfg, args[1:]); err != nil {
+				logging.EzPrint(err.Error())
+				return exitCode(err)
+			}
+			return exitOK
+		}
+	}
 
-	// Parse flags
-	flag.Parse()
+	output, err := figaro.NewOutput(figaro.OutputMode(*outputPtr))
+	if err != nil {
+		logging.EzPrint(err.Error())
+		return exitError
+	}
 
-	// init MCP
-	servers, err := getServers()
+	// fails the run through the output, so that scripts get a well formed error too
+	fail := func(session *figaro.Session, err error) int {
+		output.Finish(session, err)
+		return exitCode(err)
+	}
+
+	session, err := selectSession(*sessionPtr, *continuePtr)
 	if err != nil {
-		logging.EzPrint(err)
+		return fail(nil, err)
 	}
 
-	figaro, cancel, err := figaro.SummonFigaro(ctx, tp, *servers)
-	defer cancel(ctx.Err())
+	model, err := selectModel(cfg, *modePtr, isFlagSet("m"), session.Model)
+	if err != nil {
+		return fail(session, err)
+	}
+
+	if err := cfg.Generation.Validate(); err != nil {
+		return fail(session, err)
+	}
 
+	approvals, err := newApprovals(*yesPtr, *readOnlyPtr)
 	if err != nil {
-		return
+		return fail(session, err)
 	}
 
-	// Use the flag value
-	args := flag.Args()
-	if len(args) > 0 {
-		figaro.Request(args, modePtr)
-		cancel(nil)
-		return
-	} else {
-		logging.EzPrint("Nothing to say now.  Bye bye.")
-		cancel(nil)
+	attachments, err := readAttachments(files, len(args) > 0)
+	if err != nil {
+		return fail(session, err)
+	}
+
+	system, err := systemPrompt(*systemPtr, *systemFilePtr)
+	if err != nil {
+		return fail(session, err)
+	}
+
+	interactive := len(args) == 0 && len(attachments) == 0
+	if interactive && *outputPtr != string(figaro.OutputText) {
+		return fail(session, fmt.Errorf("--output %s needs a prompt", *outputPtr))
+	}
+
+	servers, err := serverRegistry(cfg, cfg.EnabledServers())
+	if err != nil {
+		return fail(session, err)
 	}
+
+	interrupts, stopInterrupts := handleInterrupts(cancel)
+	defer stopInterrupts()
+
+	// init MCP
+	figaro, cancel, err := figaro.SummonFigaro(ctx, tp, servers, figaro.WithLimits(cfg.Limits))
+	if err != nil {
+		return fail(session, err)
+	}
+	defer cancel(ctx.Err())
+	reportToolCollisions(figaro.ToolCollisions())
+
+	figaro.UseSession(session)
+	figaro.SetModel(model)
+	figaro.SetSystemPrompt(system...)
+	figaro.SetGeneration(cfg.Generation)
+	figaro.SetPrices(cfg.GetPrices())
+	figaro.SetCompaction(cfg.Compaction)
+	figaro.SetProviders(cfg.Providers)
+	figaro.SetOutput(output)
+	figaro.SetApprovals(approvals)
+
+	if interactive {
+		if err := runRepl(ctx, figaro, cfg, interrupts); err != nil {
+			logging.EzPrint(err.Error())
+			return exitError
+		}
+		return exitOK
+	}
+
+	requestCtx, requestDone := interrupts.request(ctx)
+	err = figaro.Request(requestCtx, strings.Join(args, " "), attachments...)
+	requestDone()
+	if finishErr := output.Finish(session, err); finishErr != nil && err == nil {
+		err = finishErr
+	}
+	cancel(nil)
+	return exitCode(err)
 }
 
-func getServers() (*figaro.ServerRegistry, error) {
-	homeDir, err := os.UserHomeDir()
+// Loads the configuration layers from the working directory, with the command line flags on top.
+func loadConfig(generationFlags *generationFlags) (*config.Config, error) {
+	workingDir, err := os.Getwd()
 	if err != nil {
 		return nil, err
 	}
-	filePath := filepath.Join(homeDir, ".figaro", "servers.json")
-	data, err := os.ReadFile(filePath)
+	return config.Load(workingDir, func(cfg *config.Config) {
+		cfg.Generation = generationFlags.apply(cfg.Generation)
+	})
+}
+
+func loggingOpts(cfg *config.Config) []logging.LoggingOptFunc {
+	opts := []logging.LoggingOptFunc{logging.WithServiceName("figaro")}
+	if cfg == nil {
+		return opts
+	}
+	if cfg.Logging.Path != "" {
+		opts = append(opts, logging.WithFilename(cfg.Logging.Path))
+	}
+	return append(opts, logging.WithRotation(
+		cfg.Logging.MaxSizeMB, cfg.Logging.MaxAgeDays, cfg.Logging.MaxBackups, cfg.Logging.Compress))
+}
+
+func reportToolCollisions(collisions []string) {
+	for _, collision := range collisions {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", collision)
+	}
+}
+
+// Lists the named servers for SummonFigaro.
+func serverRegistry(cfg *config.Config, names []string) (figaro.ServerRegistry, error) {
+	registry := figaro.ServerRegistry{
+		DockerServers: make([]figaro.DockerServer, 0, len(names)),
+	}
+	for _, name := range names {
+		server, ok := cfg.Servers[name]
+		if !ok {
+			return registry, fmt.Errorf("unknown server %q, run 'figaro servers list' to see them", name)
+		}
+		if !server.IsDefined() {
+			return registry, fmt.Errorf("server %q needs one of image_name, container_name or id, run 'figaro config validate' for details", name)
+		}
+		registry.DockerServers = append(registry.DockerServers, figaro.DockerServer{
+			ContainerDefinition: server.ContainerDefinition,
+			Name:                name,
+			ToolPrefix:          server.GetToolPrefix(name),
+		})
+	}
+	return registry, nil
+}
+
+func exitCode(err error) int {
+	switch figaro.ClassifyError(err) {
+	case "":
+		return exitOK
+	case figaro.ErrorKindModel:
+		return exitModel
+	case figaro.ErrorKindTool:
+		return exitTool
+	case figaro.ErrorKindTimeout:
+		return exitTimeout
+	case figaro.ErrorKindInterrupted:
+		return exitInterrupted
+	default:
+		return exitError
+	}
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// Reads the files passed with -f, '-' standing for stdin.  Anything piped to stdin is attached as well when there
+// is a prompt, if it comes soon enough, but is otherwise left alone: without a prompt, stdin is where the interactive
+// session reads its prompts from.
+func readAttachments(paths []string, prompt bool) ([]*figaro.Attachment, error) {
+	attachments := make([]*figaro.Attachment, 0, len(paths)+1)
+	readStdin, explicit := prompt, false
+	for _, path := range paths {
+		if path == "-" {
+			readStdin, explicit = true, true
+			continue
+		}
+		attachment, err := figaro.ReadAttachment(path)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, attachment)
+	}
+	if !readStdin {
+		return attachments, nil
+	}
+
+	stdin, err := figaro.ReadStdinAttachment(explicit)
 	if err != nil {
 		return nil, err
 	}
+	if stdin != nil {
+		attachments = append(attachments, stdin)
+	}
+	return attachments, nil
+}
 
-	// Unmarshal into struct and add the ID
-	var config figaro.ServerRegistry
-	if err := json.Unmarshal(data, &config); err != nil {
+// Gathers the system prompt: the global and project instructions files, then --system-file, then --system.
+func systemPrompt(text string, path string) ([]string, error) {
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	instructions, err := figaro.FindInstructions(workingDir)
+	if err != nil {
 		return nil, err
 	}
 
-	return &config, nil
+	parts := make([]string, 0, len(instructions)+2)
+	for _, found := range instructions {
+		parts = append(parts, found.Text)
+	}
+	if path != "" {
+		fromFile, err := figaro.ReadInstructions(path)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, fromFile.Text)
+	}
+	if text != "" {
+		parts = append(parts, text)
+	}
+	return parts, nil
+}
+
+// Flags overriding the generation settings of the config file.
+type generationFlags struct {
+	maxTokens        *int64
+	temperature      *float64
+	topP             *float64
+	topK             *int64
+	stop             stringList
+	maxContinuations *int
+	think            *int64
+}
+
+func registerGenerationFlags() *generationFlags {
+	f := &generationFlags{
+		maxTokens:        flag.Int64("max-tokens", config.DefaultMaxTokens, "Maximum number of tokens in each reply"),
+		temperature:      flag.Float64("temperature", 1, "Sampling temperature, from 0 to 1"),
+		topP:             flag.Float64("top-p", 1, "Nucleus sampling threshold"),
+		topK:             flag.Int64("top-k", 0, "Only sample from the top K options for each token"),
+		maxContinuations: flag.Int("max-continuations", 0, "Continue a reply cut off by max-tokens up to this many times"),
+		think:            flag.Int64("think", 0, "Let the model think for up to `N` tokens before replying; at least 1024"),
+	}
+	flag.Var(&f.stop, "stop", "Stop generating at this `sequence`; may be repeated")
+	return f
+}
+
+// Returns the generation settings with the flags that were set on the command line taking precedence.
+func (f *generationFlags) apply(generation config.Generation) config.Generation {
+	if isFlagSet("max-tokens") {
+		generation.MaxTokens = *f.maxTokens
+	}
+	if isFlagSet("temperature") {
+		generation.Temperature = f.temperature
+	}
+	if isFlagSet("top-p") {
+		generation.TopP = f.topP
+	}
+	if isFlagSet("top-k") {
+		generation.TopK = f.topK
+	}
+	if isFlagSet("stop") {
+		generation.StopSequences = f.stop
+	}
+	if isFlagSet("max-continuations") {
+		generation.MaxContinuations = *f.maxContinuations
+	}
+	if isFlagSet("think") {
+		generation.ThinkingBudget = *f.think
+	}
+	return generation
+}
+
+// A flag that may be repeated, collecting every value.
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
@@ -20,7 +20,9 @@ type Client struct {
 	jsonrpc.StdioClient `json:"-"`
 	TargetServer        Server
 	Tools               []Tool // TODO: replace with interface method and implement a cache with updates
-	TracerProvider      trace.TracerProvider
+	// What the server answered to initialize: its name, version and capabilities
+	Handshake      InitializeResult
+	TracerProvider trace.TracerProvider
 }
 
 // executes mcp handshake and initializes tools
@@ -47,6 +49,13 @@ func Initialize(ctx context.Context, server dockerbridge.ContainerDefinition, rp
 
 	span.AddEvent("Initialize response", trace.WithAttributes(
 		attribute.String("res1", logging.EzMarshal(res1))))
+	if res1.Error != nil {
+		return nil, fmt.Errorf("initialize failed: %w", res1.Error)
+	}
+	err = mapstructure.Decode(res1.Result, &client.Handshake)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode initialize response: %w", err)
+	}
 
 	err = client.Notify(ctx, "notifications/initialized", InitializedNotification{})
 	if err != nil {
@@ -69,6 +78,18 @@ func Initialize(ctx context.Context, server dockerbridge.ContainerDefinition, rp
 	return &client, nil
 }
 
+// Has the jsonrpc client send notifications/cancelled for the requests it gives up on, so that the server can stop
+// a tool call nobody waits for.  initialize is never cancelled, as the protocol has it.
+func WithCancellation() jsonrpc.OptsFunc {
+	return jsonrpc.WithCancelNotification("notifications/cancelled", func(request jsonrpc.Message[any], cause error) (any, bool) {
+		if request.Method == "initialize" {
+			return nil, false
+		}
+		reason := cause.Error()
+		return CancelledNotificationParams{RequestID: request.ID, Reason: &reason}, true
+	})
+}
+
 func createMcpClient(server dockerbridge.ContainerDefinition, client *jsonrpc.StdioClient, tp trace.TracerProvider) Client {
 	return Client{
 		StdioClient:    *client,
@@ -76,3 +97,61 @@ func createMcpClient(server dockerbridge.ContainerDefinition, client *jsonrpc.St
 		TracerProvider: tp,
 	}
 }
+
+// DecodeCallToolResult reads the result of a tools/call response.  Content items come back as TextContent,
+// ImageContent, AudioContent or EmbeddedResource, the resource being TextResourceContents or
+// BlobResourceContents, and any other kind of item as it was received.
+func DecodeCallToolResult(result any) (*CallToolResult, error) {
+	var callResult CallToolResult
+	if err := mapstructure.Decode(result, &callResult); err != nil {
+		return nil, fmt.Errorf("failed to decode tool result: %w", err)
+	}
+	for i, item := range callResult.Content {
+		content, err := decodeContent(item)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode tool result content %d: %w", i, err)
+		}
+		callResult.Content[i] = content
+	}
+	return &callResult, nil
+}
+
+func decodeContent(item any) (any, error) {
+	fields, ok := item.(map[string]any)
+	if !ok {
+		return item, nil
+	}
+	var err error
+	switch fields["type"] {
+	case "text":
+		var content TextContent
+		err = mapstructure.Decode(fields, &content)
+		return content, err
+	case "image":
+		var content ImageContent
+		err = mapstructure.Decode(fields, &content)
+		return content, err
+	case "audio":
+		var content AudioContent
+		err = mapstructure.Decode(fields, &content)
+		return content, err
+	case "resource":
+		var content EmbeddedResource
+		if err = mapstructure.Decode(fields, &content); err != nil {
+			return nil, err
+		}
+		resource, _ := content.Resource.(map[string]any)
+		if _, isBlob := resource["blob"]; isBlob {
+			var blob BlobResourceContents
+			err = mapstructure.Decode(resource, &blob)
+			content.Resource = blob
+		} else {
+			var text TextResourceContents
+			err = mapstructure.Decode(resource, &text)
+			content.Resource = text
+		}
+		return content, err
+	default:
+		return item, nil
+	}
+}
@@ -0,0 +1,161 @@
+package mcp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// Validate checks arguments against the schema, as far as the keywords tools use in practice go: type, required,
+// enum, properties, additionalProperties and items.  Every problem found is reported, one per line.
+func (schema ToolInputSchema) Validate(args map[string]any) error {
+	properties := make(map[string]any, len(schema.Properties))
+	for name, property := range schema.Properties {
+		properties[name] = property
+	}
+	required := make([]any, 0, len(schema.Required))
+	for _, name := range schema.Required {
+		required = append(required, name)
+	}
+	object := map[string]any{"type": "object", "properties": properties, "required": required}
+
+	if args == nil {
+		args = map[string]any{}
+	}
+	problems := validateValue("", args, object)
+	if len(problems) == 0 {
+		return nil
+	}
+	errs := make([]error, 0, len(problems))
+	for _, problem := range problems {
+		errs = append(errs, errors.New(problem))
+	}
+	return errors.Join(errs...)
+}
+
+func validateValue(path string, value any, schema map[string]any) []string {
+	problem := func(format string, args ...any) string {
+		if path == "" {
+			return fmt.Sprintf(format, args...)
+		}
+		return path + ": " + fmt.Sprintf(format, args...)
+	}
+
+	if types := schemaTypes(schema["type"]); len(types) > 0 {
+		actual := jsonType(value)
+		matches := false
+		for _, expected := range types {
+			if expected == actual || (expected == "number" && actual == "integer") {
+				matches = true
+			}
+		}
+		if !matches {
+			return []string{problem("expected %s, got %s", strings.Join(types, " or "), actual)}
+		}
+	}
+
+	problems := make([]string, 0)
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+			}
+		}
+		if !found {
+			problems = append(problems, problem("must be one of %v", enum))
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		for _, name := range schemaStrings(schema["required"]) {
+			if _, ok := value[name]; !ok {
+				problems = append(problems, problem("missing required property %q", name))
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, known := properties[name].(map[string]any)
+			if !known {
+				if additional, ok := schema["additionalProperties"].(bool); ok && !additional {
+					problems = append(problems, problem("unknown property %q", name))
+				}
+				continue
+			}
+			problems = append(problems, validateValue(joinPath(path, name), value[name], property)...)
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				problems = append(problems, validateValue(fmt.Sprintf("%s[%d]", path, i), item, items)...)
+			}
+		}
+	}
+	return problems
+}
+
+// The json type of a decoded value, telling integers apart from other numbers as JSON Schema does.
+func jsonType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case int, int64:
+		return "integer"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+// "type" may be a single type name or a list of them.
+func schemaTypes(value any) []string {
+	if name, ok := value.(string); ok {
+		return []string{name}
+	}
+	return schemaStrings(value)
+}
+
+func schemaStrings(value any) []string {
+	switch value := value.(type) {
+	case []string:
+		return value
+	case []any:
+		result := make([]string, 0, len(value))
+		for _, item := range value {
+			if s, ok := item.(string); ok {
+				result = append(result, s)
+			}
+		}
+		return result
+	default:
+		return nil
+	}
+}
+
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"figaro/anthropicbridge"
+	"figaro/config"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"text/tabwriter"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func runModelsCommand(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	defaultModel := anthropicbridge.DefaultModel
+	if cfg.Model != "" {
+		var err error
+		defaultModel, err = resolveModel(cfg, cfg.Model)
+		if err != nil {
+			return fmt.Errorf("config: %w", err)
+		}
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tCONSTANT\t")
+	for _, model := range anthropicbridge.KnownModels {
+		notes := ""
+		if model.ID == defaultModel {
+			notes = "(default)"
+		}
+		if model.Deprecated {
+			notes += "(deprecated)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", model.ID, model.Constant, notes)
+	}
+
+	if len(cfg.Providers) > 0 {
+		fmt.Fprintln(w, "\t\t")
+		fmt.Fprintln(w, "PROVIDER\tBASE URL\t")
+		for _, name := range slices.Sorted(maps.Keys(cfg.Providers)) {
+			fmt.Fprintf(w, "%s:\t%s\t\n", name, cfg.Providers[name].BaseURL)
+		}
+	}
+
+	if len(cfg.Aliases) > 0 {
+		fmt.Fprintln(w, "\t\t")
+		fmt.Fprintln(w, "ALIAS\tMODEL\t")
+		for _, alias := range anthropicbridge.SortedAliases(cfg.Aliases) {
+			fmt.Fprintf(w, "%s\t%s\t\n", alias, cfg.Aliases[alias])
+		}
+	}
+	return w.Flush()
+}
+
+// Resolves name as -m does, also taking models of the configured providers, named provider:model, which figaro
+// cannot list.
+func resolveModel(cfg *config.Config, name string) (anthropic.Model, error) {
+	target := name
+	if aliased, ok := cfg.Aliases[name]; ok {
+		target = aliased
+	}
+	if _, _, ok := config.SplitModel(target, cfg.Providers); ok {
+		return target, nil
+	}
+	// given the alias rather than its target, which may be an id the sdk does not know about yet
+	return anthropicbridge.ResolveModel(name, cfg.Aliases)
+}
+
+// Picks the model for this run: the -m flag wins, then the model the session was last used with, then the
+// configured default.
+func selectModel(cfg *config.Config, flagValue string, flagSet bool, sessionModel string) (string, error) {
+	switch {
+	case flagSet:
+		return resolveModel(cfg, flagValue)
+	case sessionModel != "":
+		return sessionModel, nil
+	case cfg.Model != "":
+		model, err := resolveModel(cfg, cfg.Model)
+		if err != nil {
+			return "", fmt.Errorf("config: %w", err)
+		}
+		return model, nil
+	default:
+		return anthropicbridge.DefaultModel, nil
+	}
+}
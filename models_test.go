@@ -0,0 +1,35 @@
+package main
+
+import (
+	"figaro/config"
+	"testing"
+)
+
+func TestResolveModelAliases(t *testing.T) {
+	cfg := &config.Config{
+		Aliases: map[string]string{
+			"next":  "claude-5-opus-20270101",
+			"local": "ollama:llama3",
+			"fast":  "ModelClaude3_5HaikuLatest",
+		},
+		Providers: map[string]config.Provider{"ollama": {Type: config.ProviderOpenAI}},
+	}
+
+	for name, want := range map[string]string{
+		// an id the sdk does not know about yet, which only an alias can name
+		"next":                   "claude-5-opus-20270101",
+		"local":                  "ollama:llama3",
+		"fast":                   "claude-3-5-haiku-latest",
+		"ollama:mistral":         "ollama:mistral",
+		"claude-3-opus-20240229": "claude-3-opus-20240229",
+	} {
+		model, err := resolveModel(cfg, name)
+		if err != nil || model != want {
+			t.Errorf("resolveModel(%q) = %q, %v, want %q", name, model, err, want)
+		}
+	}
+
+	if _, err := resolveModel(cfg, "claude-5-opus-20270101"); err == nil {
+		t.Error("an unknown id was accepted without an alias")
+	}
+}
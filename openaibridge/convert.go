@@ -0,0 +1,345 @@
+package openaibridge
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// The parts of the chat completions protocol figaro uses.
+
+type chatRequest struct {
+	Model         string         `json:"model"`
+	Messages      []chatMessage  `json:"messages"`
+	MaxTokens     int64          `json:"max_tokens,omitempty"`
+	Temperature   *float64       `json:"temperature,omitempty"`
+	TopP          *float64       `json:"top_p,omitempty"`
+	Stop          []string       `json:"stop,omitempty"`
+	Tools         []chatTool     `json:"tools,omitempty"`
+	ToolChoice    string         `json:"tool_choice,omitempty"`
+	Stream        bool           `json:"stream,omitempty"`
+	StreamOptions *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatMessage struct {
+	Role string `json:"role"`
+	// A string, a list of contentPart, or nil for an assistant message that only calls tools
+	Content    any        `json:"content"`
+	ToolCalls  []toolCall `json:"tool_calls,omitempty"`
+	ToolCallID string     `json:"tool_call_id,omitempty"`
+}
+
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+type toolCall struct {
+	// Only set in stream chunks, where a call comes in pieces, and left out of requests as the first is 0
+	Index    int          `json:"index,omitempty"`
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function functionCall `json:"function"`
+}
+
+type functionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type chatTool struct {
+	Type     string       `json:"type"`
+	Function functionSpec `json:"function"`
+}
+
+type functionSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Parameters  any    `json:"parameters"`
+}
+
+type chatResponse struct {
+	ID      string       `json:"id"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage"`
+	Error   *chatError   `json:"error"`
+}
+
+type chatChoice struct {
+	// Message is set in whole replies, Delta in stream chunks
+	Message      chatDelta `json:"message"`
+	Delta        chatDelta `json:"delta"`
+	FinishReason string    `json:"finish_reason"`
+}
+
+type chatDelta struct {
+	Content   string     `json:"content"`
+	ToolCalls []toolCall `json:"tool_calls"`
+	// What reasoning models think before replying, as vLLM and others report it
+	ReasoningContent string `json:"reasoning_content"`
+}
+
+type chatUsage struct {
+	PromptTokens        int64 `json:"prompt_tokens"`
+	CompletionTokens    int64 `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+type chatError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+func (e *chatError) Error() string {
+	if e.Type == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%s: %s", e.Type, e.Message)
+}
+
+// Translates a request in the Anthropic format.  What chat completions cannot express is left out: cache
+// breakpoints, thinking, and PDFs, which are replaced by a note.
+func toChatRequest(params anthropic.MessageNewParams, model string) chatRequest {
+	request := chatRequest{
+		Model:     model,
+		MaxTokens: params.MaxTokens,
+		Stop:      params.StopSequences,
+	}
+	if params.Temperature.IsPresent() {
+		temperature := params.Temperature.Value
+		request.Temperature = &temperature
+	}
+	if params.TopP.IsPresent() {
+		topP := params.TopP.Value
+		request.TopP = &topP
+	}
+
+	system := make([]string, 0, len(params.System))
+	for _, block := range params.System {
+		system = append(system, block.Text)
+	}
+	if len(system) > 0 {
+		request.Messages = append(request.Messages, chatMessage{Role: "system", Content: strings.Join(system, "\n\n")})
+	}
+	for _, message := range params.Messages {
+		if message.Role == anthropic.MessageParamRoleAssistant {
+			request.Messages = append(request.Messages, toAssistantMessage(message))
+		} else {
+			request.Messages = append(request.Messages, toUserMessages(message)...)
+		}
+	}
+
+	for _, tool := range params.Tools {
+		if tool.OfTool == nil {
+			continue
+		}
+		request.Tools = append(request.Tools, chatTool{
+			Type: "function",
+			Function: functionSpec{
+				Name:        tool.OfTool.Name,
+				Description: tool.OfTool.Description.Value,
+				Parameters:  toParameters(tool.OfTool.InputSchema),
+			},
+		})
+	}
+	if params.ToolChoice.OfToolChoiceNone != nil {
+		request.ToolChoice = "none"
+	}
+	return request
+}
+
+func toParameters(schema anthropic.ToolInputSchemaParam) map[string]any {
+	parameters := make(map[string]any, len(schema.ExtraFields)+2)
+	for key, value := range schema.ExtraFields {
+		parameters[key] = value
+	}
+	parameters["type"] = "object"
+	properties := schema.Properties
+	if properties == nil {
+		properties = map[string]any{}
+	}
+	parameters["properties"] = properties
+	return parameters
+}
+
+// A user turn becomes a tool message for each tool result, which have to come first, right after the assistant
+// message calling the tools, and a user message for the rest.
+func toUserMessages(message anthropic.MessageParam) []chatMessage {
+	messages := make([]chatMessage, 0, 1)
+	parts := make([]contentPart, 0, len(message.Content))
+	for _, block := range message.Content {
+		switch {
+		case block.OfRequestToolResultBlock != nil:
+			result := block.OfRequestToolResultBlock
+			messages = append(messages, chatMessage{
+				Role:       "tool",
+				ToolCallID: result.ToolUseID,
+				Content:    toolResultText(result),
+			})
+		case block.OfRequestTextBlock != nil:
+			parts = append(parts, contentPart{Type: "text", Text: block.OfRequestTextBlock.Text})
+		case block.OfRequestImageBlock != nil:
+			parts = append(parts, imagePart(block.OfRequestImageBlock))
+		case block.OfRequestDocumentBlock != nil:
+			parts = append(parts, contentPart{Type: "text", Text: documentText(block.OfRequestDocumentBlock)})
+		}
+	}
+	if len(parts) > 0 {
+		messages = append(messages, chatMessage{Role: "user", Content: parts})
+	}
+	return messages
+}
+
+func toAssistantMessage(message anthropic.MessageParam) chatMessage {
+	assistant := chatMessage{Role: "assistant"}
+	text := make([]string, 0, 1)
+	for _, block := range message.Content {
+		switch {
+		case block.OfRequestTextBlock != nil:
+			text = append(text, block.OfRequestTextBlock.Text)
+		case block.OfRequestToolUseBlock != nil:
+			toolUse := block.OfRequestToolUseBlock
+			arguments, err := json.Marshal(toolUse.Input)
+			if err != nil {
+				arguments = []byte("{}")
+			}
+			assistant.ToolCalls = append(assistant.ToolCalls, toolCall{
+				ID:       toolUse.ID,
+				Type:     "function",
+				Function: functionCall{Name: toolUse.Name, Arguments: string(arguments)},
+			})
+		}
+	}
+	if len(text) > 0 {
+		assistant.Content = strings.Join(text, "")
+	}
+	return assistant
+}
+
+// Tool messages only take text, so images in a result are noted rather than sent.
+func toolResultText(result *anthropic.ToolResultBlockParam) string {
+	text := make([]string, 0, len(result.Content))
+	if result.IsError.Value {
+		text = append(text, "Error:")
+	}
+	for _, content := range result.Content {
+		if t := content.GetText(); t != nil {
+			text = append(text, *t)
+		} else if content.OfRequestImageBlock != nil {
+			text = append(text, "[image left out: tool results can only hold text here]")
+		}
+	}
+	return strings.Join(text, "\n")
+}
+
+func imagePart(image *anthropic.ImageBlockParam) contentPart {
+	url := ""
+	switch {
+	case image.Source.OfBase64ImageSource != nil:
+		source := image.Source.OfBase64ImageSource
+		url = fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data)
+	case image.Source.OfURLImageSource != nil:
+		url = image.Source.OfURLImageSource.URL
+	}
+	return contentPart{Type: "image_url", ImageURL: &imageURL{URL: url}}
+}
+
+func documentText(document *anthropic.DocumentBlockParam) string {
+	if source := document.Source.OfPlainTextSource; source != nil {
+		return source.Data
+	}
+	return "[document left out: only plain text documents can be sent to this model]"
+}
+
+// Turns a reply into a message in the Anthropic format, going through json so that the sdk's union types are
+// set up as if the reply had come from Anthropic's API.
+func toMessage(id string, model string, text string, calls []toolCall, finishReason string, usage *chatUsage) (*anthropic.Message, error) {
+	type block struct {
+		Type  string          `json:"type"`
+		Text  *string         `json:"text,omitempty"`
+		ID    string          `json:"id,omitempty"`
+		Name  string          `json:"name,omitempty"`
+		Input json.RawMessage `json:"input,omitempty"`
+	}
+	content := make([]block, 0, len(calls)+1)
+	if text != "" {
+		content = append(content, block{Type: "text", Text: &text})
+	}
+	stopReason := toStopReason(finishReason, len(calls) > 0)
+	for _, call := range calls {
+		input := json.RawMessage(call.Function.Arguments)
+		if len(input) == 0 {
+			input = json.RawMessage("{}")
+		} else if !json.Valid(input) {
+			if finishReason != "length" {
+				return nil, fmt.Errorf("the arguments of the call to %s are not valid json: %s", call.Function.Name, input)
+			}
+			// cut off by the token limit: none of the calls are run, the reply is reported as cut off instead
+			content = content[:0]
+			if text != "" {
+				content = append(content, block{Type: "text", Text: &text})
+			}
+			stopReason = anthropic.MessageStopReasonMaxTokens
+			break
+		}
+		content = append(content, block{Type: "tool_use", ID: call.ID, Name: call.Function.Name, Input: input})
+	}
+
+	reply := map[string]any{
+		"id":          id,
+		"type":        "message",
+		"role":        "assistant",
+		"model":       model,
+		"content":     content,
+		"stop_reason": stopReason,
+		"usage":       toAnthropicUsage(usage),
+	}
+	raw, err := json.Marshal(reply)
+	if err != nil {
+		return nil, err
+	}
+	var message anthropic.Message
+	if err := json.Unmarshal(raw, &message); err != nil {
+		return nil, err
+	}
+	return &message, nil
+}
+
+func toStopReason(finishReason string, calledTools bool) anthropic.MessageStopReason {
+	switch {
+	case finishReason == "tool_calls" || calledTools:
+		return anthropic.MessageStopReasonToolUse
+	case finishReason == "length":
+		return anthropic.MessageStopReasonMaxTokens
+	default:
+		return anthropic.MessageStopReasonEndTurn
+	}
+}
+
+func toAnthropicUsage(usage *chatUsage) anthropic.Usage {
+	if usage == nil {
+		return anthropic.Usage{}
+	}
+	cached := int64(0)
+	if usage.PromptTokensDetails != nil {
+		cached = usage.PromptTokensDetails.CachedTokens
+	}
+	return anthropic.Usage{
+		InputTokens:          usage.PromptTokens - cached,
+		CacheReadInputTokens: cached,
+		OutputTokens:         usage.CompletionTokens,
+	}
+}
@@ -0,0 +1,54 @@
+package openaibridge
+
+import (
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+func TestToUserMessagesPutsToolResultsFirst(t *testing.T) {
+	message := anthropic.NewUserMessage(
+		anthropic.NewTextBlock("and now?"),
+		anthropic.NewToolResultBlock("call_a", "sunny", false),
+		anthropic.NewToolResultBlock("call_b", "no such city", true),
+	)
+
+	messages := toUserMessages(message)
+	if len(messages) != 3 {
+		t.Fatalf("got %d messages, want two tool messages and the user's", len(messages))
+	}
+	for i, id := range []string{"call_a", "call_b"} {
+		if messages[i].Role != "tool" || messages[i].ToolCallID != id {
+			t.Errorf("message %d = %s for %q, want the tool result for %s", i, messages[i].Role, messages[i].ToolCallID, id)
+		}
+	}
+	if messages[1].Content != "Error:\nno such city" {
+		t.Errorf("failed result = %q, want it marked as an error", messages[1].Content)
+	}
+	parts, ok := messages[2].Content.([]contentPart)
+	if messages[2].Role != "user" || !ok || len(parts) != 1 || parts[0].Text != "and now?" {
+		t.Errorf("last message = %+v, want the user's text", messages[2])
+	}
+}
+
+func TestToMessageDropsCutOffToolCalls(t *testing.T) {
+	calls := []toolCall{
+		{ID: "call_a", Function: functionCall{Name: "weather", Arguments: `{"city":"Paris"}`}},
+		{ID: "call_b", Function: functionCall{Name: "weather", Arguments: `{"city":"Ly`}},
+	}
+
+	message, err := toMessage("id", "local:llama3", "Checking.", calls, "length", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.StopReason != anthropic.MessageStopReasonMaxTokens {
+		t.Errorf("stop reason = %q, want max_tokens", message.StopReason)
+	}
+	if len(message.Content) != 1 || message.Content[0].Type != "text" {
+		t.Errorf("content = %+v, want only the text", message.Content)
+	}
+
+	if _, err := toMessage("id", "local:llama3", "", calls, "tool_calls", nil); err == nil {
+		t.Error("invalid arguments were accepted without the reply being cut off")
+	}
+}
@@ -0,0 +1,278 @@
+package openaibridge
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"figaro/config"
+	"figaro/llm"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+)
+
+// Talks to a server offering OpenAI's chat completions API, which local runners like Ollama, vLLM and llama.cpp
+// do as well as OpenAI itself.
+type OpenAIBridge struct {
+	tracerProvider trace.TracerProvider
+	httpClient     *http.Client
+	name           string
+	baseURL        string
+	apiKey         string
+}
+
+type Opts struct {
+	tracerProvider trace.TracerProvider
+	httpClient     *http.Client
+}
+
+type OptsFunc func(o *Opts)
+
+func WithLogging(tp trace.TracerProvider) OptsFunc {
+	return func(o *Opts) {
+		o.tracerProvider = tp
+	}
+}
+
+func WithHTTPClient(client *http.Client) OptsFunc {
+	return func(o *Opts) {
+		o.httpClient = client
+	}
+}
+
+// Sets up the provider configured under name, whose models are named "name:model".
+func InitOpenAI(name string, provider config.Provider, opts ...OptsFunc) (OpenAIBridge, error) {
+	o := Opts{
+		tracerProvider: noop.NewTracerProvider(),
+		httpClient:     http.DefaultClient,
+	}
+	for _, optFunc := range opts {
+		optFunc(&o)
+	}
+	if provider.BaseURL == "" {
+		return OpenAIBridge{}, fmt.Errorf("provider %q has no base_url", name)
+	}
+
+	return OpenAIBridge{
+		tracerProvider: o.tracerProvider,
+		httpClient:     o.httpClient,
+		name:           name,
+		baseURL:        strings.TrimSuffix(provider.BaseURL, "/"),
+		apiKey:         provider.APIKey,
+	}, nil
+}
+
+func (bridge *OpenAIBridge) NewMessage(ctx context.Context, input anthropic.MessageNewParams) (*anthropic.Message, error) {
+	tracer := bridge.tracerProvider.Tracer("openaibridge")
+	ctx, span := tracer.Start(ctx, "NewMessage")
+	defer span.End()
+
+	response, err := bridge.post(ctx, toChatRequest(input, bridge.model(input)))
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	var reply chatResponse
+	if err := json.NewDecoder(response.Body).Decode(&reply); err != nil {
+		return nil, fmt.Errorf("failed to read the reply: %w", err)
+	}
+	if reply.Error != nil {
+		return nil, reply.Error
+	}
+	if len(reply.Choices) == 0 {
+		return nil, errors.New("the reply has no choices")
+	}
+	choice := reply.Choices[0]
+	return toMessage(reply.ID, string(input.Model), choice.Message.Content, choice.Message.ToolCalls,
+		choice.FinishReason, reply.Usage)
+}
+
+// Streams the reply as typed events.  Text comes before the tool calls in the message, so the blocks are numbered
+// that way; reasoning is streamed but not kept, as it has no signature to send back.
+func (bridge *OpenAIBridge) StreamMessage(ctx context.Context, input anthropic.MessageNewParams) (<-chan llm.StreamEvent, error) {
+	tracer := bridge.tracerProvider.Tracer("openaibridge")
+	ctx, span := tracer.Start(ctx, "StreamMessage")
+
+	request := toChatRequest(input, bridge.model(input))
+	request.Stream = true
+	request.StreamOptions = &streamOptions{IncludeUsage: true}
+	response, err := bridge.post(ctx, request)
+	if err != nil {
+		span.End()
+		return nil, err
+	}
+
+	// unbuffered, so that every event is taken in order, reasoning before the text that follows it
+	events := make(chan llm.StreamEvent)
+	go func() {
+		defer close(events)
+		defer span.End()
+		defer response.Body.Close()
+
+		var (
+			id           string
+			text         strings.Builder
+			calls        []toolCall
+			finishReason string
+			usage        *chatUsage
+			started      bool
+			done         bool
+			// where the tool calls start among the blocks, after the text if there is any
+			firstCall = -1
+		)
+		fail := func(err error) {
+			span.AddEvent("Stream failed", trace.WithAttributes(attribute.String("error", err.Error())))
+			events <- llm.StreamEvent{Type: llm.StreamError, Err: err}
+		}
+		// the block being streamed, the text or the last tool call
+		open := func() int {
+			if len(calls) > 0 {
+				return firstCall + len(calls) - 1
+			} else if text.Len() > 0 {
+				return 0
+			}
+			return -1
+		}
+		stopOpen := func() {
+			if index := open(); index >= 0 {
+				events <- llm.StreamEvent{Type: llm.StreamContentBlockStop, Index: index}
+			}
+		}
+
+		scanner := bufio.NewScanner(response.Body)
+		// tool calls can come as a single large line
+		scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			data, ok := strings.CutPrefix(scanner.Text(), "data:")
+			if !ok {
+				continue
+			}
+			data = strings.TrimSpace(data)
+			if data == "[DONE]" {
+				done = true
+				break
+			}
+			var chunk chatResponse
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				fail(fmt.Errorf("failed to read the stream: %w", err))
+				return
+			}
+			if chunk.Error != nil {
+				fail(chunk.Error)
+				return
+			}
+			if !started {
+				started = true
+				id = chunk.ID
+				events <- llm.StreamEvent{Type: llm.StreamMessageStart, ID: id}
+			}
+			if chunk.Usage != nil {
+				usage = chunk.Usage
+				events <- llm.StreamEvent{Type: llm.StreamUsage, Usage: toAnthropicUsage(usage)}
+			}
+			for _, choice := range chunk.Choices {
+				if choice.Delta.ReasoningContent != "" {
+					events <- llm.StreamEvent{Type: llm.StreamThinkingDelta, Index: max(open(), 0), Text: choice.Delta.ReasoningContent}
+				}
+				if choice.Delta.Content != "" {
+					text.WriteString(choice.Delta.Content)
+					events <- llm.StreamEvent{Type: llm.StreamTextDelta, Index: 0, Text: choice.Delta.Content}
+				}
+				for _, delta := range choice.Delta.ToolCalls {
+					if delta.Index >= len(calls) {
+						stopOpen()
+						if firstCall < 0 {
+							firstCall = min(text.Len(), 1)
+						}
+						calls = accumulateToolCall(calls, delta)
+						call := calls[len(calls)-1]
+						events <- llm.StreamEvent{Type: llm.StreamToolUseStart, Index: open(), ID: call.ID, Name: call.Function.Name}
+					} else {
+						calls = accumulateToolCall(calls, delta)
+					}
+					if delta.Function.Arguments != "" {
+						events <- llm.StreamEvent{Type: llm.StreamInputJSONDelta, Index: firstCall + delta.Index, Text: delta.Function.Arguments}
+					}
+				}
+				if choice.FinishReason != "" {
+					finishReason = choice.FinishReason
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			fail(fmt.Errorf("failed to read the stream: %w", err))
+			return
+		}
+		if !done && finishReason == "" {
+			fail(errors.New("the stream ended before the reply was done"))
+			return
+		}
+		stopOpen()
+
+		message, err := toMessage(id, string(input.Model), text.String(), calls, finishReason, usage)
+		if err != nil {
+			fail(err)
+			return
+		}
+		events <- llm.StreamEvent{Type: llm.StreamMessageStop, Message: message}
+	}()
+	return events, nil
+}
+
+// Strips the provider's name off the model, leaving the name the server knows it by.
+func (bridge *OpenAIBridge) model(input anthropic.MessageNewParams) string {
+	model, _ := strings.CutPrefix(string(input.Model), bridge.name+":")
+	return model
+}
+
+func (bridge *OpenAIBridge) post(ctx context.Context, chat chatRequest) (*http.Response, error) {
+	body, err := json.Marshal(chat)
+	if err != nil {
+		return nil, err
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, bridge.baseURL+"/chat/completions", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("Content-Type", "application/json")
+	if bridge.apiKey != "" {
+		request.Header.Set("Authorization", "Bearer "+bridge.apiKey)
+	}
+
+	response, err := bridge.httpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	if response.StatusCode/100 != 2 {
+		defer response.Body.Close()
+		snippet, _ := io.ReadAll(io.LimitReader(response.Body, 1024))
+		return nil, fmt.Errorf("%s %s: %s", request.URL, response.Status, strings.TrimSpace(string(snippet)))
+	}
+	return response, nil
+}
+
+// Tool calls stream in pieces keyed by their index: the first carries the id and name, the rest more of the
+// arguments.  Calls the server does not name are given an id, which tool results need to refer to them.
+func accumulateToolCall(calls []toolCall, delta toolCall) []toolCall {
+	for len(calls) <= delta.Index {
+		calls = append(calls, toolCall{Type: "function", ID: fmt.Sprintf("call_%d", len(calls))})
+	}
+	call := &calls[delta.Index]
+	if delta.ID != "" {
+		call.ID = delta.ID
+	}
+	if delta.Function.Name != "" {
+		call.Function.Name = delta.Function.Name
+	}
+	call.Function.Arguments += delta.Function.Arguments
+	return calls
+}
@@ -0,0 +1,207 @@
+package openaibridge
+
+import (
+	"context"
+	"encoding/json"
+	"figaro/config"
+	"figaro/llm"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go"
+)
+
+// Starts a stand-in server answering /chat/completions with handle, and a bridge talking to it as provider "local".
+func newTestBridge(t *testing.T, handle func(w http.ResponseWriter, request chatRequest)) OpenAIBridge {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer secret" {
+			t.Errorf("Authorization = %q, want the api key", got)
+		}
+		var request chatRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			t.Errorf("failed to decode the request: %v", err)
+		}
+		if request.Model != "llama3" {
+			t.Errorf("model = %q, want it without the provider's name", request.Model)
+		}
+		handle(w, request)
+	}))
+	t.Cleanup(server.Close)
+
+	bridge, err := InitOpenAI("local", config.Provider{
+		Type:    config.ProviderOpenAI,
+		BaseURL: server.URL + "/v1/",
+		APIKey:  "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bridge
+}
+
+func testParams() anthropic.MessageNewParams {
+	return anthropic.MessageNewParams{
+		Model:     "local:llama3",
+		MaxTokens: 100,
+		Messages:  []anthropic.MessageParam{anthropic.NewUserMessage(anthropic.NewTextBlock("weather in Paris?"))},
+	}
+}
+
+// Writes each chunk as a server-sent event.
+func writeStream(w http.ResponseWriter, chunks ...string) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	for _, chunk := range chunks {
+		fmt.Fprintf(w, "data: %s\n\n", chunk)
+	}
+}
+
+func collect(t *testing.T, events <-chan llm.StreamEvent) []llm.StreamEvent {
+	t.Helper()
+	collected := make([]llm.StreamEvent, 0)
+	for event := range events {
+		collected = append(collected, event)
+	}
+	return collected
+}
+
+func TestNewMessage(t *testing.T) {
+	bridge := newTestBridge(t, func(w http.ResponseWriter, request chatRequest) {
+		if request.Stream {
+			t.Error("a whole reply was asked for as a stream")
+		}
+		fmt.Fprint(w, `{
+			"id": "chatcmpl-1",
+			"choices": [{"message": {"content": "Sunny."}, "finish_reason": "stop"}],
+			"usage": {"prompt_tokens": 12, "completion_tokens": 3, "prompt_tokens_details": {"cached_tokens": 2}}
+		}`)
+	})
+
+	message, err := bridge.NewMessage(context.Background(), testParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if message.ID != "chatcmpl-1" || message.StopReason != anthropic.MessageStopReasonEndTurn {
+		t.Errorf("got id %q and stop reason %q", message.ID, message.StopReason)
+	}
+	if len(message.Content) != 1 || message.Content[0].Text != "Sunny." {
+		t.Errorf("content = %+v, want the text of the reply", message.Content)
+	}
+	usage := message.Usage
+	if usage.InputTokens != 10 || usage.CacheReadInputTokens != 2 || usage.OutputTokens != 3 {
+		t.Errorf("usage = %d in, %d from cache, %d out", usage.InputTokens, usage.CacheReadInputTokens, usage.OutputTokens)
+	}
+}
+
+func TestStreamMessage(t *testing.T) {
+	bridge := newTestBridge(t, func(w http.ResponseWriter, request chatRequest) {
+		if !request.Stream || request.StreamOptions == nil || !request.StreamOptions.IncludeUsage {
+			t.Error("the stream was not asked for with its usage")
+		}
+		writeStream(w,
+			`{"id":"chatcmpl-2","choices":[{"delta":{"role":"assistant","content":"Let me "}}]}`,
+			`{"id":"chatcmpl-2","choices":[{"delta":{"content":"check."}}]}`,
+			`{"id":"chatcmpl-2","choices":[{"delta":{"tool_calls":[{"index":0,"id":"call_w","type":"function","function":{"name":"weather","arguments":"{\"ci"}}]}}]}`,
+			`{"id":"chatcmpl-2","choices":[{"delta":{"tool_calls":[{"index":0,"function":{"arguments":"ty\":\"Paris\"}"}}]}}]}`,
+			`{"id":"chatcmpl-2","choices":[{"delta":{},"finish_reason":"tool_calls"}]}`,
+			`{"id":"chatcmpl-2","choices":[],"usage":{"prompt_tokens":20,"completion_tokens":9}}`,
+			`[DONE]`,
+		)
+	})
+
+	events, err := bridge.StreamMessage(context.Background(), testParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	collected := collect(t, events)
+
+	want := []struct {
+		Type  llm.StreamEventType
+		Index int
+		Text  string
+	}{
+		{llm.StreamMessageStart, 0, ""},
+		{llm.StreamTextDelta, 0, "Let me "},
+		{llm.StreamTextDelta, 0, "check."},
+		{llm.StreamContentBlockStop, 0, ""},
+		{llm.StreamToolUseStart, 1, ""},
+		{llm.StreamInputJSONDelta, 1, `{"ci`},
+		{llm.StreamInputJSONDelta, 1, `ty":"Paris"}`},
+		{llm.StreamUsage, 0, ""},
+		{llm.StreamContentBlockStop, 1, ""},
+		{llm.StreamMessageStop, 0, ""},
+	}
+	if len(collected) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(collected), len(want), collected)
+	}
+	for i, event := range collected {
+		if event.Type != want[i].Type || event.Index != want[i].Index || event.Text != want[i].Text {
+			t.Errorf("event %d = %s at %d %q, want %s at %d %q", i,
+				event.Type, event.Index, event.Text, want[i].Type, want[i].Index, want[i].Text)
+		}
+	}
+	if start := collected[4]; start.ID != "call_w" || start.Name != "weather" {
+		t.Errorf("tool_use_start = %q %q, want call_w weather", start.ID, start.Name)
+	}
+
+	message := collected[len(collected)-1].Message
+	if message.StopReason != anthropic.MessageStopReasonToolUse {
+		t.Errorf("stop reason = %q, want tool_use", message.StopReason)
+	}
+	if len(message.Content) != 2 {
+		t.Fatalf("content = %+v, want text and a tool call", message.Content)
+	}
+	if message.Content[0].Text != "Let me check." {
+		t.Errorf("text = %q", message.Content[0].Text)
+	}
+	toolUse := message.Content[1]
+	if toolUse.Type != "tool_use" || toolUse.ID != "call_w" || string(toolUse.Input) != `{"city":"Paris"}` {
+		t.Errorf("tool call = %s %s %s", toolUse.Type, toolUse.ID, toolUse.Input)
+	}
+	if message.Usage.InputTokens != 20 || message.Usage.OutputTokens != 9 {
+		t.Errorf("usage = %d in, %d out", message.Usage.InputTokens, message.Usage.OutputTokens)
+	}
+}
+
+func TestStreamMessageCutShort(t *testing.T) {
+	bridge := newTestBridge(t, func(w http.ResponseWriter, request chatRequest) {
+		writeStream(w, `{"id":"chatcmpl-3","choices":[{"delta":{"content":"Sun"}}]}`)
+	})
+
+	events, err := bridge.StreamMessage(context.Background(), testParams())
+	if err != nil {
+		t.Fatal(err)
+	}
+	collected := collect(t, events)
+	last := collected[len(collected)-1]
+	if last.Type != llm.StreamError || last.Err == nil {
+		t.Fatalf("the stream ended with %+v, want an error", last)
+	}
+	for _, event := range collected {
+		if event.Type == llm.StreamMessageStop {
+			t.Error("a stream that never finished was reported as done")
+		}
+	}
+}
+
+func TestErrorStatus(t *testing.T) {
+	bridge := newTestBridge(t, func(w http.ResponseWriter, request chatRequest) {
+		http.Error(w, `{"error":{"message":"model not found"}}`, http.StatusNotFound)
+	})
+
+	if _, err := bridge.NewMessage(context.Background(), testParams()); err == nil ||
+		!strings.Contains(err.Error(), "404") || !strings.Contains(err.Error(), "model not found") {
+		t.Errorf("NewMessage failed with %v, want the status and body", err)
+	}
+	if _, err := bridge.StreamMessage(context.Background(), testParams()); err == nil ||
+		!strings.Contains(err.Error(), "404") {
+		t.Errorf("StreamMessage failed with %v, want the status", err)
+	}
+}
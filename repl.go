@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"figaro/config"
+	"figaro/figaro"
+	"figaro/logging"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/term"
+)
+
+const replPrompt = "figaro> "
+
+// A slash command available in the interactive session.  Returning true from run ends the session.
+type slashCommand struct {
+	usage       string
+	description string
+	run         func(ctx context.Context, f *figaro.Figaro, cfg *config.Config, args []string) (bool, error)
+}
+
+var slashCommands map[string]slashCommand
+
+func init() {
+	slashCommands = map[string]slashCommand{
+		"/help": {
+			usage:       "/help",
+			description: "List the available commands",
+			run: func(ctx context.Context, f *figaro.Figaro, cfg *config.Config, args []string) (bool, error) {
+				for _, name := range []string{"/help", "/tools", "/model", "/clear", "/save", "/exit"} {
+					command := slashCommands[name]
+					fmt.Printf("  %-16s %s\n", command.usage, command.description)
+				}
+				return false, nil
+			},
+		},
+		"/tools": {
+			usage:       "/tools",
+			description: "List the tools offered by the MCP servers",
+			run: func(ctx context.Context, f *figaro.Figaro, cfg *config.Config, args []string) (bool, error) {
+				for _, tool := range f.GetAllTools() {
+					description := ""
+					if tool.Description != nil {
+						description = firstLine(*tool.Description)
+					}
+					fmt.Printf("  %-24s %s\n", tool.Name, description)
+				}
+				return false, nil
+			},
+		},
+		"/model": {
+			usage:       "/model [name]",
+			description: "Show or change the model",
+			run: func(ctx context.Context, f *figaro.Figaro, cfg *config.Config, args []string) (bool, error) {
+				if len(args) > 0 {
+					model, err := resolveModel(cfg, args[0])
+					if err != nil {
+						return false, err
+					}
+					f.SetModel(model)
+				}
+				fmt.Printf("model: %s\n", f.Model())
+				return false, nil
+			},
+		},
+		"/clear": {
+			usage:       "/clear",
+			description: "Start over with an empty conversation",
+			run: func(ctx context.Context, f *figaro.Figaro, cfg *config.Config, args []string) (bool, error) {
+				f.ClearConversation()
+				fmt.Println("conversation cleared")
+				return false, nil
+			},
+		},
+		"/save": {
+			usage:       "/save <path>",
+			description: "Write the conversation to a json file",
+			run: func(ctx context.Context, f *figaro.Figaro, cfg *config.Config, args []string) (bool, error) {
+				if len(args) == 0 {
+					return false, errors.New("usage: /save <path>")
+				}
+				if err := f.SaveConversation(args[0]); err != nil {
+					return false, err
+				}
+				fmt.Printf("conversation saved to %s\n", args[0])
+				return false, nil
+			},
+		},
+		"/exit": {
+			usage:       "/exit",
+			description: "Leave the session",
+			run: func(ctx context.Context, f *figaro.Figaro, cfg *config.Config, args []string) (bool, error) {
+				return true, nil
+			},
+		},
+	}
+	slashCommands["/quit"] = slashCommands["/exit"]
+}
+
+// Runs an interactive, multi-turn session against the provided figaro until the user exits or input ends.
+// The MCP servers stay up and the conversation is kept between prompts.
+func runRepl(ctx context.Context, f *figaro.Figaro, cfg *config.Config, interrupts *interrupter) error {
+	readLine, closeReader, err := newLineReader()
+	if err != nil {
+		return err
+	}
+	defer closeReader()
+
+	fmt.Println("Figaro qua, Figaro là.  Type /help for commands, /exit to leave.")
+	for {
+		line, err := readLineContext(ctx, readLine)
+		if err == io.EOF || ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		if strings.HasPrefix(line, "/") {
+			fields := strings.Fields(line)
+			command, ok := slashCommands[fields[0]]
+			if !ok {
+				fmt.Printf("unknown command %s, try /help\n", fields[0])
+				continue
+			}
+			exit, err := command.run(ctx, f, cfg, fields[1:])
+			if err != nil {
+				fmt.Println(err)
+			}
+			if exit {
+				return nil
+			}
+			continue
+		}
+
+		requestCtx, requestDone := interrupts.request(ctx)
+		err = f.Request(requestCtx, line)
+		requestDone()
+		// an interruption was reported as it happened
+		if err != nil && !errors.Is(err, figaro.ErrInterrupted) {
+			logging.EzPrint(err.Error())
+		}
+	}
+}
+
+// Reads a line unless ctx ends first, as it does when Ctrl-C shuts figaro down while it waits for input that is
+// not read from a terminal.  A terminal reads Ctrl-C as a key instead, which ends the input.
+func readLineContext(ctx context.Context, readLine func() (string, error)) (string, error) {
+	type read struct {
+		line string
+		err  error
+	}
+	done := make(chan read, 1)
+	go func() {
+		line, err := readLine()
+		done <- read{line, err}
+	}()
+	select {
+	case <-ctx.Done():
+		return "", context.Cause(ctx)
+	case r := <-done:
+		return r.line, r.err
+	}
+}
+
+// Returns a function reading one line of input at a time.  When stdin is a terminal, lines can be edited and
+// previous lines recalled with the arrow keys; the history is kept across sessions in ~/.figaro/history.
+// Otherwise, lines are read as they come.
+func newLineReader() (func() (string, error), func(), error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		scanner := bufio.NewScanner(os.Stdin)
+		return func() (string, error) {
+			if !scanner.Scan() {
+				if err := scanner.Err(); err != nil {
+					return "", err
+				}
+				return "", io.EOF
+			}
+			return scanner.Text(), nil
+		}, func() {}, nil
+	}
+
+	terminal := term.NewTerminal(struct {
+		io.Reader
+		io.Writer
+	}{os.Stdin, os.Stdout}, replPrompt)
+
+	history, err := loadHistory()
+	if err != nil {
+		return nil, nil, err
+	}
+	terminal.History = history
+
+	readLine := func() (string, error) {
+		// only hold the terminal in raw mode while reading so that streamed output renders normally
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return "", err
+		}
+		defer term.Restore(fd, state)
+		return terminal.ReadLine()
+	}
+	return readLine, history.close, nil
+}
+
+const maxHistory = 500
+
+// term.History backed by a file so that previous prompts can be recalled in later sessions.
+type fileHistory struct {
+	entries []string
+	file    *os.File
+}
+
+func loadHistory() (*fileHistory, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+	dir := filepath.Join(homeDir, ".figaro")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, "history")
+	history := &fileHistory{}
+	if data, err := os.ReadFile(path); err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				history.entries = append(history.entries, line)
+			}
+		}
+	}
+	if len(history.entries) > maxHistory {
+		history.entries = history.entries[len(history.entries)-maxHistory:]
+	}
+
+	history.file, err = os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return history, nil
+}
+
+func (h *fileHistory) Add(entry string) {
+	if entry == "" || (len(h.entries) > 0 && h.entries[len(h.entries)-1] == entry) {
+		return
+	}
+	h.entries = append(h.entries, entry)
+	if len(h.entries) > maxHistory {
+		h.entries = h.entries[1:]
+	}
+	fmt.Fprintln(h.file, entry)
+}
+
+func (h *fileHistory) Len() int {
+	return len(h.entries)
+}
+
+// At returns the idx-th most recent entry, 0 being the latest.
+func (h *fileHistory) At(idx int) string {
+	return h.entries[len(h.entries)-1-idx]
+}
+
+func (h *fileHistory) close() {
+	h.file.Close()
+}
+
+func firstLine(s string) string {
+	if idx := strings.IndexByte(s, '\n'); idx != -1 {
+		return s[:idx]
+	}
+	return s
+}
@@ -1,20 +1,341 @@
 package main
 
-// import "figaro/mcp"
-
-var braveImageName = "mcp/brave-search"
-
-// var Brave = DockerServer{
-// 	ImageName: &braveImageName,
-// 	Server: mcp.Server{
-// 		Env: &[]string{"BRAVE_API_KEY"},
-// 	},
-// }
-
-// var imageName = "mcp/brave-search"
-// var Brave = DockerServer{
-// 	ImageName: &imageName,
-// 	Server: mcp.Server{
-// 		Env: &[]string{"BRAVE_API_KEY"},
-// 	},
-// }
+import (
+	"context"
+	"errors"
+	"figaro/config"
+	"figaro/dockerbridge"
+	"figaro/jsonrpc"
+	"figaro/mcp"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func runServersCommand(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	action, args, err := subcommand(args, "list", "add", "remove", "enable", "disable", "test")
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		listServers(cfg)
+		return nil
+	case "add":
+		return addServer(args)
+	case "remove":
+		return removeServer(args)
+	case "enable", "disable":
+		return switchServer(cfg, args, action == "disable")
+	default:
+		if len(args) != 1 {
+			return errors.New("usage: figaro servers test <name>")
+		}
+		return testServer(ctx, tp, cfg, args[0])
+	}
+}
+
+func listServers(cfg *config.Config) {
+	names := make([]string, 0, len(cfg.Servers))
+	for name := range cfg.Servers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tSTATUS\tCONTAINER")
+	for _, name := range names {
+		server := cfg.Servers[name]
+		status := "enabled"
+		if server.Disabled {
+			status = "disabled"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", name, status, describeContainer(server.ContainerDefinition))
+	}
+	w.Flush()
+}
+
+func describeContainer(def dockerbridge.ContainerDefinition) string {
+	parts := make([]string, 0, 3)
+	if def.ImageName != nil {
+		parts = append(parts, "image "+*def.ImageName)
+	}
+	if def.ContainerName != nil {
+		parts = append(parts, "name "+*def.ContainerName)
+	}
+	if def.ID != nil {
+		parts = append(parts, "id "+*def.ID)
+	}
+	if len(parts) == 0 {
+		return "(not defined)"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func addServer(args []string) error {
+	flags := flag.NewFlagSet("servers add", flag.ContinueOnError)
+	image := flags.String("image", "", "Docker `image` to create the container from")
+	containerName := flags.String("container", "", "`name` of the container to use, or to give the one created")
+	id := flags.String("id", "", "`id` of an existing container to use")
+	var env stringList
+	flags.Var(&env, "env", "Environment `variable` for the container, as NAME to pass it through or NAME=value; may be repeated")
+	toolPrefix := flags.String("tool-prefix", "", "Name the server's tools `prefix`__tool rather than <name>__tool; \"-\" leaves them unprefixed")
+	disabled := flags.Bool("disabled", false, "Add the server switched off")
+	project := flags.Bool("project", false, "Write to .figaro/config.json in the current directory rather than ~/.figaro/config.json")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: figaro servers add <name> --image <image> [--container <name>] [--id <id>] [--env NAME[=value]]... [--tool-prefix <prefix>] [--disabled] [--project]")
+	}
+	name := args[0]
+	if err := config.ValidateServerName(name); err != nil {
+		return err
+	}
+
+	server := config.Server{Disabled: *disabled}
+	if *image != "" {
+		server.ImageName = image
+	}
+	if *containerName != "" {
+		server.ContainerName = containerName
+	}
+	if *id != "" {
+		server.ID = id
+	}
+	switch *toolPrefix {
+	case "":
+	case "-":
+		server.ToolPrefix = new(string)
+	default:
+		server.ToolPrefix = toolPrefix
+	}
+	if len(env) > 0 {
+		variables := []string(env)
+		server.Env = &variables
+	}
+	if !server.IsDefined() {
+		return errors.New("one of --image, --container or --id is required")
+	}
+
+	path, err := serverConfigPath(*project)
+	if err != nil {
+		return err
+	}
+	err = config.Edit(path, func(values map[string]any) error {
+		return config.SetServer(values, name, server)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("added %s to %s\n", name, path)
+	return nil
+}
+
+func removeServer(args []string) error {
+	flags := flag.NewFlagSet("servers remove", flag.ContinueOnError)
+	project := flags.Bool("project", false, "Remove from .figaro/config.json in the current directory")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: figaro servers remove <name> [--project]")
+	}
+	name := args[0]
+
+	path, err := serverConfigPath(*project)
+	if err != nil {
+		return err
+	}
+	removed, err := editIfExists(path, func(values map[string]any) (bool, error) {
+		return config.RemoveServer(values, name)
+	})
+	if err != nil {
+		return err
+	}
+	if removed {
+		fmt.Printf("removed %s from %s\n", name, path)
+	}
+	if *project {
+		if !removed {
+			return fmt.Errorf("server %q is not defined in %s", name, path)
+		}
+		return nil
+	}
+
+	// servers from before named servers live in servers.json, where they may only be switched off in config.json
+	globalDir, err := config.GlobalDir()
+	if err != nil {
+		return err
+	}
+	legacyPath := filepath.Join(globalDir, "servers.json")
+	removedLegacy, err := editIfExists(legacyPath, func(values map[string]any) (bool, error) {
+		return config.RemoveLegacyServer(values, name)
+	})
+	if err != nil {
+		return err
+	}
+	if removedLegacy {
+		fmt.Printf("removed %s from %s\n", name, legacyPath)
+	} else if !removed {
+		return fmt.Errorf("server %q is not defined in %s or %s", name, path, legacyPath)
+	}
+	return nil
+}
+
+// Edits the config file at path unless there is none, reporting whether edit changed anything.
+func editIfExists(path string, edit func(values map[string]any) (bool, error)) (bool, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	changed := false
+	err := config.Edit(path, func(values map[string]any) error {
+		var err error
+		changed, err = edit(values)
+		if err == nil && !changed {
+			// leave the file alone
+			return errUnchanged
+		}
+		return err
+	})
+	if errors.Is(err, errUnchanged) {
+		return false, nil
+	}
+	return changed, err
+}
+
+var errUnchanged = errors.New("unchanged")
+
+func switchServer(cfg *config.Config, args []string, disabled bool) error {
+	action := "enable"
+	if disabled {
+		action = "disable"
+	}
+	flags := flag.NewFlagSet("servers "+action, flag.ContinueOnError)
+	project := flags.Bool("project", false, "Only "+action+" the server for the current directory")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return fmt.Errorf("usage: figaro servers %s <name> [--project]", action)
+	}
+	name := args[0]
+	if _, ok := cfg.Servers[name]; !ok {
+		return fmt.Errorf("unknown server %q, run 'figaro servers list' to see them", name)
+	}
+
+	path, err := serverConfigPath(*project)
+	if err != nil {
+		return err
+	}
+	err = config.Edit(path, func(values map[string]any) error {
+		return config.SetServerDisabled(values, name, disabled)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%sd %s in %s\n", action, name, path)
+	return nil
+}
+
+// Starts a single server and goes through the MCP handshake with it, no model involved.
+func testServer(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, name string) error {
+	server, ok := cfg.Servers[name]
+	if !ok {
+		return fmt.Errorf("unknown server %q, run 'figaro servers list' to see them", name)
+	}
+	if !server.IsDefined() {
+		return fmt.Errorf("server %q needs one of image_name, container_name or id", name)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Limits.GetRequestTimeout())
+	defer cancel()
+
+	start := time.Now()
+	connection, _, err := dockerbridge.Setup(ctx, server.ContainerDefinition, tp)
+	if err != nil {
+		return fmt.Errorf("%s: could not start the container: %w", name, err)
+	}
+	client, _, err := jsonrpc.NewStdioClient[string](ctx, connection, tp, jsonrpc.WithTimeout(cfg.Limits.GetToolTimeout()))
+	if err != nil {
+		return fmt.Errorf("%s: could not connect: %w", name, err)
+	}
+	mcpClient, err := mcp.Initialize(ctx, server.ContainerDefinition, client, tp)
+	if err != nil {
+		return fmt.Errorf("%s: handshake failed: %w", name, err)
+	}
+	elapsed := time.Since(start).Round(time.Millisecond)
+
+	handshake := mcpClient.Handshake
+	capabilities := make([]string, 0, 4)
+	if handshake.Capabilities.Tools != nil {
+		capabilities = append(capabilities, "tools")
+	}
+	if handshake.Capabilities.Prompts != nil {
+		capabilities = append(capabilities, "prompts")
+	}
+	if handshake.Capabilities.Resources != nil {
+		capabilities = append(capabilities, "resources")
+	}
+	if handshake.Capabilities.Logging != nil {
+		capabilities = append(capabilities, "logging")
+	}
+	toolNames := make([]string, 0, len(mcpClient.Tools))
+	for _, tool := range mcpClient.Tools {
+		toolNames = append(toolNames, tool.Name)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "handshake:\tok in %v\n", elapsed)
+	fmt.Fprintf(w, "server:\t%s %s\n", handshake.ServerInfo.Name, handshake.ServerInfo.Version)
+	fmt.Fprintf(w, "protocol:\t%s\n", handshake.ProtocolVersion)
+	fmt.Fprintf(w, "capabilities:\t%s\n", strings.Join(capabilities, ", "))
+	if handshake.Instructions != nil {
+		fmt.Fprintf(w, "instructions:\t%s\n", firstLine(*handshake.Instructions))
+	}
+	fmt.Fprintf(w, "tools:\t%d\n", len(mcpClient.Tools))
+	if len(toolNames) > 0 {
+		fmt.Fprintf(w, "\t%s\n", strings.Join(toolNames, ", "))
+	}
+	return w.Flush()
+}
+
+// Where servers commands write: the global config file, or the one of the current directory.
+func serverConfigPath(project bool) (string, error) {
+	if !project {
+		return config.GlobalPath()
+	}
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(workingDir, ".figaro", "config.json"), nil
+}
+
+// Parses flags wherever they appear among the arguments, which flag.Parse alone stops doing at the first
+// positional one.  Returns the positional arguments.
+func parseInterspersed(flags *flag.FlagSet, args []string) ([]string, error) {
+	positional := make([]string, 0, len(args))
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			return positional, nil
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
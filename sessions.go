@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"figaro/config"
+	"figaro/figaro"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/anthropics/anthropic-sdk-go"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func runSessionsCommand(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	action, args, err := subcommand(args, "list", "show", "delete")
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		sessions, err := figaro.ListSessions()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tUPDATED\tTURNS\tCOST\tMODEL")
+		for _, session := range sessions {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", session.Name, session.UpdatedAt.Format("2006-01-02 15:04"),
+				len(session.Messages), session.Usage.FormatCost(), session.Model)
+		}
+		return w.Flush()
+	case "show":
+		if len(args) != 1 {
+			return errors.New("usage: figaro sessions show <name>")
+		}
+		session, err := figaro.LoadSession(args[0])
+		if err != nil {
+			return err
+		}
+		printTranscript(session.Messages)
+		return nil
+	case "delete":
+		if len(args) == 0 {
+			return errors.New("usage: figaro sessions delete <name>...")
+		}
+		for _, name := range args {
+			if err := figaro.DeleteSession(name); err != nil {
+				return err
+			}
+			fmt.Printf("deleted %s\n", name)
+		}
+	}
+	return nil
+}
+
+// Picks the session a prompt is recorded in: the named one, the most recent one if continuing, or a new one.
+func selectSession(name string, resume bool) (*figaro.Session, error) {
+	if name != "" && resume {
+		return nil, errors.New("--session and --continue cannot be used together")
+	}
+	if name != "" {
+		return figaro.LoadOrCreateSession(name)
+	}
+	if resume {
+		session, err := figaro.LatestSession()
+		if errors.Is(err, figaro.ErrSessionNotFound) {
+			return nil, errors.New("no session to continue")
+		}
+		return session, err
+	}
+	return figaro.NewSession("")
+}
+
+func printTranscript(messages []anthropic.MessageParam) {
+	for _, message := range messages {
+		fmt.Printf("── %s\n", message.Role)
+		for _, block := range message.Content {
+			switch {
+			case block.OfRequestTextBlock != nil:
+				fmt.Println(block.OfRequestTextBlock.Text)
+			case block.OfRequestToolUseBlock != nil:
+				fmt.Printf("[tool_use %s] %s\n", block.OfRequestToolUseBlock.Name, anyToJson(block.OfRequestToolUseBlock.Input))
+			case block.OfRequestToolResultBlock != nil:
+				fmt.Printf("[tool_result %s]\n", block.OfRequestToolResultBlock.ToolUseID)
+				for _, content := range block.OfRequestToolResultBlock.Content {
+					if text := content.GetText(); text != nil {
+						fmt.Println(*text)
+					} else if content.OfRequestImageBlock != nil {
+						fmt.Println("[image]")
+					}
+				}
+			case block.OfRequestImageBlock != nil:
+				fmt.Println("[image]")
+			case block.OfRequestDocumentBlock != nil:
+				fmt.Println("[document]")
+			case block.OfRequestThinkingBlock != nil:
+				fmt.Printf("[thinking] %s\n", block.OfRequestThinkingBlock.Thinking)
+			case block.OfRequestRedactedThinkingBlock != nil:
+				fmt.Println("[redacted thinking]")
+			}
+		}
+		fmt.Println()
+	}
+}
+
+func anyToJson(v any) string {
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return string(bytes)
+}
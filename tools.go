@@ -0,0 +1,258 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"figaro/config"
+	"figaro/figaro"
+	"figaro/mcp"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func runToolsCommand(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	action, args, err := subcommand(args, "list", "call")
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "list":
+		return listTools(ctx, tp, cfg, args)
+	default:
+		return callTool(ctx, tp, cfg, args)
+	}
+}
+
+// Prints the tools of each enabled server, or of the one asked for, with their input schemas.
+func listTools(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("tools list", flag.ContinueOnError)
+	server := flags.String("server", "", "Only list the tools of the named `server`")
+	asJson := flags.Bool("json", false, "Print the tools as json, as the servers describe them")
+	if _, err := parseInterspersed(flags, args); err != nil {
+		return err
+	}
+
+	names := cfg.EnabledServers()
+	if *server != "" {
+		names = []string{*server}
+	}
+
+	// one server at a time, so that tools can be listed under the server offering them
+	toolsByServer := make(map[string][]mcp.Tool, len(names))
+	owners := make(map[string]string)
+	collisions := make([]string, 0)
+	for _, name := range names {
+		tools, err := serverTools(ctx, tp, cfg, name)
+		if err != nil {
+			return err
+		}
+		toolsByServer[name] = tools
+		for _, tool := range tools {
+			if owner, taken := owners[tool.Name]; taken {
+				collisions = append(collisions, fmt.Sprintf(
+					"tool %q is offered by both %q and %q, set tool_prefix to tell them apart", tool.Name, owner, name))
+			} else {
+				owners[tool.Name] = name
+			}
+		}
+	}
+	reportToolCollisions(collisions)
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(toolsByServer)
+	}
+	for _, name := range names {
+		fmt.Printf("── %s\n", name)
+		for _, tool := range toolsByServer[name] {
+			printTool(tool)
+		}
+	}
+	return nil
+}
+
+func serverTools(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, name string) ([]mcp.Tool, error) {
+	registry, err := serverRegistry(cfg, []string{name})
+	if err != nil {
+		return nil, err
+	}
+	f, cancel, err := figaro.SummonFigaro(ctx, tp, registry, figaro.WithLimits(cfg.Limits))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	defer cancel(nil)
+	reportToolCollisions(f.ToolCollisions())
+	return f.GetAllTools(), nil
+}
+
+func printTool(tool mcp.Tool) {
+	fmt.Printf("%s\n", tool.Name)
+	if tool.Description != nil {
+		for _, line := range strings.Split(strings.TrimSpace(*tool.Description), "\n") {
+			fmt.Printf("    %s\n", line)
+		}
+	}
+	if hints := toolHints(tool.Annotations); len(hints) > 0 {
+		fmt.Printf("    [%s]\n", strings.Join(hints, ", "))
+	}
+	schema, err := json.MarshalIndent(tool.InputSchema, "    ", "  ")
+	if err != nil {
+		schema = []byte(err.Error())
+	}
+	fmt.Printf("    schema: %s\n\n", schema)
+}
+
+func toolHints(annotations *mcp.ToolAnnotations) []string {
+	hints := make([]string, 0, 4)
+	if annotations == nil {
+		return hints
+	}
+	hint := func(value *bool, name string) {
+		if value != nil && *value {
+			hints = append(hints, name)
+		}
+	}
+	hint(annotations.ReadOnlyHint, "read-only")
+	hint(annotations.DestructiveHint, "destructive")
+	hint(annotations.IdempotentHint, "idempotent")
+	hint(annotations.OpenWorldHint, "open world")
+	return hints
+}
+
+// Calls one tool the way the model would, and prints what it returns.
+func callTool(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("tools call", flag.ContinueOnError)
+	server := flags.String("server", "", "Only start the named `server`")
+	rawJson := flags.String("json", "", "Arguments as a json `object`")
+	var pairs stringList
+	flags.Var(&pairs, "arg", "An argument as `key=value`, on top of --json; may be repeated")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 1 {
+		return errors.New("usage: figaro tools call <name> [--json '{...}'] [--arg key=value]... [--server <name>]")
+	}
+	name := args[0]
+
+	names := cfg.EnabledServers()
+	if *server != "" {
+		names = []string{*server}
+	}
+	registry, err := serverRegistry(cfg, names)
+	if err != nil {
+		return err
+	}
+	f, cancel, err := figaro.SummonFigaro(ctx, tp, registry, figaro.WithLimits(cfg.Limits))
+	if err != nil {
+		return err
+	}
+	defer cancel(nil)
+	reportToolCollisions(f.ToolCollisions())
+
+	tool, ok := f.GetTool(name)
+	if !ok {
+		return fmt.Errorf("unknown tool %q, run 'figaro tools list' to see them", name)
+	}
+
+	toolArgs := map[string]any{}
+	if *rawJson != "" {
+		if err := json.Unmarshal([]byte(*rawJson), &toolArgs); err != nil {
+			return fmt.Errorf("--json: %w", err)
+		}
+	}
+	for _, pair := range pairs {
+		key, value, found := strings.Cut(pair, "=")
+		if !found {
+			return fmt.Errorf("--arg %q: expected key=value", pair)
+		}
+		toolArgs[key] = argumentValue(tool.InputSchema.Properties[key], value)
+	}
+	if err := tool.InputSchema.Validate(toolArgs); err != nil {
+		return fmt.Errorf("invalid arguments for %s:\n%w", name, err)
+	}
+
+	ctx, cancelRequest := context.WithTimeout(ctx, cfg.Limits.GetRequestTimeout())
+	defer cancelRequest()
+	response, err := f.CallTool(ctx, name, toolArgs)
+	if err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return &figaro.ToolError{Tool: name, Err: response.Error}
+	}
+	result, err := mcp.DecodeCallToolResult(response.Result)
+	if err != nil {
+		return &figaro.ToolError{Tool: name, Err: err}
+	}
+
+	out := os.Stdout
+	if result.IsError {
+		out = os.Stderr
+	}
+	for i, content := range result.Content {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+		fmt.Fprintln(out, formatContent(content))
+	}
+	if result.IsError {
+		return &figaro.ToolError{Tool: name, Err: errors.New("reported an error")}
+	}
+	return nil
+}
+
+// Reads the value of --arg key=value as the property's type says, so that numbers and booleans need no quoting.
+// Strings are taken as they are, and so is anything that is not valid json, leaving it to validation.
+func argumentValue(property map[string]any, value string) any {
+	if property["type"] == "string" {
+		return value
+	}
+	var decoded any
+	if err := json.Unmarshal([]byte(value), &decoded); err != nil {
+		return value
+	}
+	return decoded
+}
+
+// Renders an item of CallToolResult.Content for the terminal: text as is, or indented if it holds json, and a
+// short description of binary content.
+func formatContent(content any) string {
+	describe := func(kind string, mimeType string, data string) string {
+		return fmt.Sprintf("[%s %s, %d bytes]", kind, mimeType, base64.RawStdEncoding.DecodedLen(len(strings.TrimRight(data, "="))))
+	}
+
+	switch content := content.(type) {
+	case mcp.TextContent:
+		var indented bytes.Buffer
+		if json.Indent(&indented, []byte(content.Text), "", "  ") == nil {
+			return indented.String()
+		}
+		return content.Text
+	case mcp.ImageContent:
+		return describe("image", content.MimeType, content.Data)
+	case mcp.AudioContent:
+		return describe("audio", content.MimeType, content.Data)
+	case mcp.EmbeddedResource:
+		switch resource := content.Resource.(type) {
+		case mcp.TextResourceContents:
+			return fmt.Sprintf("[resource %s]\n%s", resource.URI, resource.Text)
+		case mcp.BlobResourceContents:
+			mimeType := "application/octet-stream"
+			if resource.MimeType != nil {
+				mimeType = *resource.MimeType
+			}
+			return fmt.Sprintf("[resource %s] %s", resource.URI, describe("blob", mimeType, resource.Blob))
+		}
+	}
+	return anyToJson(content)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"figaro/config"
+	"figaro/figaro"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Usage added up over the requests sharing a day or a model.
+type usageGroup struct {
+	Key      string `json:"key"`
+	Requests int    `json:"requests"`
+	figaro.Usage
+}
+
+type usageReport struct {
+	ByDay   []usageGroup `json:"by_day"`
+	ByModel []usageGroup `json:"by_model"`
+	Total   usageGroup   `json:"total"`
+}
+
+// Reports the tokens used and what they cost, by day and by model, from the log kept of every request.
+func runUsageCommand(ctx context.Context, tp trace.TracerProvider, cfg *config.Config, args []string) error {
+	flags := flag.NewFlagSet("usage", flag.ContinueOnError)
+	days := flags.Int("days", 0, "Only count the last `n` days, today included")
+	session := flags.String("session", "", "Only count the requests of the named `session`")
+	asJson := flags.Bool("json", false, "Print the report as json")
+	args, err := parseInterspersed(flags, args)
+	if err != nil {
+		return err
+	}
+	if len(args) != 0 {
+		return errors.New("usage: figaro usage [--days n] [--session name] [--json]")
+	}
+
+	records, err := figaro.LoadUsage()
+	if err != nil {
+		return err
+	}
+	var since time.Time
+	if *days > 0 {
+		year, month, day := time.Now().Date()
+		since = time.Date(year, month, day-*days+1, 0, 0, 0, 0, time.Local)
+	}
+
+	byDay := make(map[string]*usageGroup)
+	byModel := make(map[string]*usageGroup)
+	report := usageReport{Total: usageGroup{Key: "total"}}
+	for _, record := range records {
+		if record.Time.Before(since) || (*session != "" && record.Session != *session) {
+			continue
+		}
+		for _, group := range []*usageGroup{
+			groupFor(byDay, record.Time.Local().Format(time.DateOnly)),
+			groupFor(byModel, string(record.Model)),
+			&report.Total,
+		} {
+			group.Requests++
+			group.Add(record.Usage)
+		}
+	}
+	report.ByDay = sortedGroups(byDay)
+	report.ByModel = sortedGroups(byModel)
+
+	if *asJson {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	if report.Total.Requests == 0 {
+		fmt.Println("no usage recorded")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	printUsageGroups(w, "DAY", report.ByDay)
+	fmt.Fprintln(w, "\t\t\t\t\t")
+	printUsageGroups(w, "MODEL", report.ByModel)
+	fmt.Fprintln(w, "\t\t\t\t\t")
+	printUsageGroups(w, "", []usageGroup{report.Total})
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if report.Total.Unpriced {
+		fmt.Println("\n+ some of the usage is of models without a price, add them under \"prices\" in the config")
+	}
+	return nil
+}
+
+func groupFor(groups map[string]*usageGroup, key string) *usageGroup {
+	group, ok := groups[key]
+	if !ok {
+		group = &usageGroup{Key: key}
+		groups[key] = group
+	}
+	return group
+}
+
+func sortedGroups(groups map[string]*usageGroup) []usageGroup {
+	sorted := make([]usageGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, *group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Key < sorted[j].Key
+	})
+	return sorted
+}
+
+func printUsageGroups(w io.Writer, heading string, groups []usageGroup) {
+	if heading != "" {
+		fmt.Fprintf(w, "%s\tREQUESTS\tINPUT\tCACHE READ\tCACHE WRITE\tOUTPUT\tCOST\n", heading)
+	}
+	for _, group := range groups {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%s\n", group.Key, group.Requests, group.InputTokens,
+			group.CacheReadInputTokens, group.CacheCreationInputTokens, group.OutputTokens, group.FormatCost())
+	}
+}